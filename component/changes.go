@@ -3,6 +3,7 @@ package component
 import (
 	"fmt"
 
+	"dmitri.shuralyov.com/app/changes/route"
 	"dmitri.shuralyov.com/service/change"
 	"github.com/shurcooL/htmlg"
 	issuescomponent "github.com/shurcooL/issuesapp/component"
@@ -12,26 +13,51 @@ import (
 )
 
 // Changes is a component that displays a page of changes,
-// with a navigation bar on top.
+// with a navigation bar on top and pagination links on the bottom.
 type Changes struct {
 	ChangesNav ChangesNav
-	Filter     change.StateFilter
+	StateTab   route.StateTab // Selected state tab.
+	Search     string         // Search query that entries match, or empty string if none.
 	Entries    []ChangeEntry
+	Pagination Pagination
+
+	// MarkAllReadURL is the URL to POST to for marking all changes as read.
+	// If empty, the "Mark all as read" button isn't displayed.
+	MarkAllReadURL string
 }
 
 func (i Changes) Render() []*html.Node {
 	// TODO: Make this much nicer.
 	// <div class="list-entry list-entry-border">
 	// 	{{render .ChangesNav}}
+	// 	{{with .MarkAllReadURL}}
+	// 		<form class="list-entry-body mark-all-read" method="post" action="{{.}}"><button type="submit">Mark all as read</button></form>
+	// 	{{end}}
 	// 	{{with .Entries}}{{range .}}
 	// 		{{render .}}
 	// 	{{end}}{{else}}
-	// 		<div style="text-align: center; margin-top: 80px; margin-bottom: 80px;">There are no {{.Filter}} changes.</div>
+	// 		<div style="text-align: center; margin-top: 80px; margin-bottom: 80px;">There are no {{.StateTab.Is}} changes.</div>
 	// 	{{end}}
 	// </div>
 
 	var ns []*html.Node
 	ns = append(ns, i.ChangesNav.Render()...)
+	if i.MarkAllReadURL != "" {
+		form := &html.Node{
+			Type: html.ElementNode, Data: atom.Form.String(),
+			Attr: []html.Attribute{
+				{Key: atom.Class.String(), Val: "list-entry-body mark-all-read"},
+				{Key: atom.Method.String(), Val: "post"},
+				{Key: atom.Action.String(), Val: i.MarkAllReadURL},
+			},
+		}
+		form.AppendChild(&html.Node{
+			Type: html.ElementNode, Data: atom.Button.String(),
+			Attr:       []html.Attribute{{Key: atom.Type.String(), Val: "submit"}},
+			FirstChild: htmlg.Text("Mark all as read"),
+		})
+		ns = append(ns, form)
+	}
 	for _, e := range i.Entries {
 		ns = append(ns, e.Render()...)
 	}
@@ -41,19 +67,25 @@ func (i Changes) Render() []*html.Node {
 			Type: html.ElementNode, Data: atom.Div.String(),
 			Attr: []html.Attribute{{Key: atom.Style.String(), Val: "text-align: center; margin-top: 80px; margin-bottom: 80px;"}},
 		}
-		switch i.Filter {
-		case change.FilterOpen:
+		switch {
+		case i.Search != "":
+			div.AppendChild(htmlg.Text("No changes matched your search."))
+		case i.StateTab.Is == "open":
 			div.AppendChild(htmlg.Text("There are no open changes."))
-		case change.FilterClosedMerged:
+		case i.StateTab.Is == "closed":
 			div.AppendChild(htmlg.Text("There are no closed/merged changes."))
-		case change.FilterAll:
+		case i.StateTab.Is == "merged":
+			div.AppendChild(htmlg.Text("There are no merged changes."))
+		case i.StateTab.Is == "unread":
+			div.AppendChild(htmlg.Text("There are no unread changes."))
+		case i.StateTab.Is == "":
 			div.AppendChild(htmlg.Text("There are no changes."))
 		}
 		ns = append(ns, div)
 	}
 
 	div := htmlg.DivClass("list-entry list-entry-border", ns...)
-	return []*html.Node{div}
+	return append([]*html.Node{div}, i.Pagination.Render()...)
 }
 
 // ChangeEntry is an entry within the list of changes.
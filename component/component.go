@@ -3,7 +3,10 @@ package component
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
+	"unicode"
 
 	"dmitri.shuralyov.com/html/belt"
 	"dmitri.shuralyov.com/service/change"
@@ -20,6 +23,63 @@ import (
 // Event is an event component.
 type Event struct {
 	Event change.TimelineItem
+
+	// Renderers, if not nil, are used for rendering the event payload.
+	// They take precedence over built-in rendering of known payload types.
+	Renderers EventRenderers
+}
+
+// EventRenderer renders events with payloads of a particular type.
+// Either field can be nil, in which case the default rendering is used.
+type EventRenderer struct {
+	// Icon returns the icon of an event with the given payload,
+	// and its color and background color in CSS syntax.
+	Icon func(payload interface{}) (icon *html.Node, color, backgroundColor string)
+
+	// Text returns the description of an event with the given payload,
+	// which follows its actor, e.g., "closed this".
+	Text func(payload interface{}) []*html.Node
+}
+
+// EventRenderers is a registry of event renderers by payload type.
+// The zero value is an empty registry ready to use.
+type EventRenderers map[reflect.Type]EventRenderer
+
+// Register registers renderer r for events with payloads of the same type as payload.
+func (rs *EventRenderers) Register(payload interface{}, r EventRenderer) {
+	if *rs == nil {
+		*rs = make(EventRenderers)
+	}
+	(*rs)[reflect.TypeOf(payload)] = r
+}
+
+// PlainText returns the description of an event with the given payload as plain text,
+// using the text content of the registered renderer's Text. It reports false
+// if there's no renderer with Text registered for the payload type.
+func (rs EventRenderers) PlainText(payload interface{}) (string, bool) {
+	r := rs.renderer(payload)
+	if r.Text == nil {
+		return "", false
+	}
+	var buf strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range r.Text(payload) {
+		walk(n)
+	}
+	return buf.String(), true
+}
+
+// renderer returns the registered renderer for payload, if any.
+func (rs EventRenderers) renderer(payload interface{}) EventRenderer {
+	return rs[reflect.TypeOf(payload)] // Lookup in nil map is okay.
 }
 
 func (e Event) Render() []*html.Node {
@@ -52,6 +112,10 @@ func (e Event) icon() *html.Node {
 		color           = "#767676"
 		backgroundColor = "#f3f3f3"
 	)
+	if r := e.Renderers.renderer(e.Event.Payload); r.Icon != nil {
+		icon, color, backgroundColor = r.Icon(e.Event.Payload)
+		return eventIcon(icon, color, backgroundColor)
+	}
 	switch p := e.Event.Payload.(type) {
 	case change.ClosedEvent:
 		icon = octicon.CircleSlash()
@@ -78,11 +142,16 @@ func (e Event) icon() *html.Node {
 		case "comment":
 			icon = octicon.X()
 		default:
-			panic("unreachable")
+			icon = octicon.Trashcan()
 		}
 	default:
 		icon = octicon.PrimitiveDot()
 	}
+	return eventIcon(icon, color, backgroundColor)
+}
+
+// eventIcon returns an event icon with the given colors.
+func eventIcon(icon *html.Node, color, backgroundColor string) *html.Node {
 	return &html.Node{
 		Type: html.ElementNode, Data: atom.Span.String(),
 		Attr: []html.Attribute{
@@ -94,6 +163,9 @@ func (e Event) icon() *html.Node {
 }
 
 func (e Event) text() []*html.Node {
+	if r := e.Renderers.renderer(e.Event.Payload); r.Text != nil {
+		return r.Text(e.Event.Payload)
+	}
 	switch p := e.Event.Payload.(type) {
 	case change.ClosedEvent:
 		ns := []*html.Node{htmlg.Text("closed this")}
@@ -164,11 +236,41 @@ func (e Event) text() []*html.Node {
 		case "comment":
 			return []*html.Node{htmlg.Text("deleted a comment")}
 		default:
-			panic("unreachable")
+			if p.Name == "" {
+				return []*html.Node{htmlg.Text("deleted a " + p.Type)}
+			}
+			return []*html.Node{htmlg.Text("deleted the "), htmlg.Strong(p.Name), htmlg.Text(" " + p.Type)}
 		}
 	default:
-		return []*html.Node{htmlg.Text("unknown event")} // TODO: See if this is optimal.
+		return []*html.Node{htmlg.Text(EventFallbackText(p))}
+	}
+}
+
+// EventFallbackText returns a generic description of an event with the given payload,
+// for payload types that there's no renderer for. It's made from the name of the type,
+// e.g., "force pushed" for a ForcePushedEvent.
+func EventFallbackText(payload interface{}) string {
+	t := reflect.TypeOf(payload)
+	if t == nil {
+		return "did something"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := strings.TrimSuffix(t.Name(), "Event")
+	if name == "" {
+		return "did something"
+	}
+	var words []string
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, name[start:i])
+			start = i
+		}
 	}
+	words = append(words, name[start:])
+	return strings.ToLower(strings.Join(words, " "))
 }
 
 // ChangeStateBadge is a component that displays the state of a change
@@ -0,0 +1,38 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/shurcooL/htmlg"
+	"golang.org/x/net/html"
+)
+
+type pinnedEvent struct{ By string }
+
+type unpinnedEvent struct{}
+
+func TestEventRenderers(t *testing.T) {
+	var rs EventRenderers // Registering on the zero value must work.
+	rs.Register(pinnedEvent{}, EventRenderer{
+		Text: func(payload interface{}) []*html.Node {
+			return []*html.Node{htmlg.Text("pinned this for "), htmlg.Strong(payload.(pinnedEvent).By)}
+		},
+	})
+	rs.Register(unpinnedEvent{}, EventRenderer{})
+
+	tests := []struct {
+		payload interface{}
+		want    string
+		wantOK  bool
+	}{
+		{payload: pinnedEvent{By: "gophers"}, want: "pinned this for gophers", wantOK: true},
+		{payload: unpinnedEvent{}, wantOK: false}, // Registered without Text.
+		{payload: struct{}{}, wantOK: false},
+	}
+	for _, tc := range tests {
+		got, ok := rs.PlainText(tc.payload)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("PlainText(%T): got %q, %v, want %q, %v", tc.payload, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package component
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/shurcooL/htmlg"
+	"github.com/shurcooL/issues"
+	issuescomponent "github.com/shurcooL/issuesapp/component"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LabelFacets is a component that displays labels of a list of changes,
+// along with how many changes have each label. Clicking a label toggles
+// filtering the list by it. Multiple selected labels must all match.
+type LabelFacets struct {
+	Facets        []LabelFacet
+	NoCounts      bool       // NoCounts indicates that counts aren't known for the entire list, so they're not displayed.
+	Path          string     // URL path of current page (needed to generate correct links).
+	Query         url.Values // URL query of current page (needed to generate correct links).
+	LabelQueryKey string     // Name of query key for selected labels. Constant, but provided externally.
+	PageQueryKey  string     // Name of query key for controlling current page. Constant, but provided externally.
+}
+
+// LabelFacet is a single label within LabelFacets.
+type LabelFacet struct {
+	Label    issues.Label
+	Count    int  // Number of changes with this label.
+	Selected bool // Selected indicates whether the list is filtered by this label.
+}
+
+func (f LabelFacets) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <div class="label-facets">
+	// 	<div class="facets-heading">Labels</div>
+	// 	{{range .Facets}}
+	// 		<a class="facet{{if .Selected}} selected{{end}}" href="...">{{render (label .Label)}}{{if not $.NoCounts}} <span class="counter">{{.Count}}</span>{{end}}</a>
+	// 	{{else}}
+	// 		<div class="gray tiny">No labels.</div>
+	// 	{{end}}
+	// </div>
+	div := htmlg.DivClass("label-facets", htmlg.DivClass("facets-heading", htmlg.Text("Labels")))
+	for _, facet := range f.Facets {
+		class := "facet"
+		title := fmt.Sprintf("Show only changes labeled %q", facet.Label.Name)
+		if facet.Selected {
+			class += " selected"
+			title = fmt.Sprintf("Stop filtering by %q", facet.Label.Name)
+		}
+		a := &html.Node{
+			Type: html.ElementNode, Data: atom.A.String(),
+			Attr: []html.Attribute{
+				{Key: atom.Class.String(), Val: class},
+				{Key: atom.Href.String(), Val: f.toggleURL(facet)},
+				{Key: atom.Title.String(), Val: title},
+			},
+		}
+		htmlg.AppendChildren(a, issuescomponent.Label{Label: facet.Label}.Render()...)
+		if !f.NoCounts {
+			a.AppendChild(htmlg.SpanClass("counter", htmlg.Text(fmt.Sprint(facet.Count))))
+		}
+		div.AppendChild(a)
+	}
+	if len(f.Facets) == 0 {
+		div.AppendChild(htmlg.DivClass("gray tiny", htmlg.Text("No labels.")))
+	}
+	return []*html.Node{div}
+}
+
+// toggleURL returns the URL of current page with facet toggled.
+// Toggling a facet starts over from the first page.
+func (f LabelFacets) toggleURL(facet LabelFacet) string {
+	q := copyQuery(f.Query)
+	q.Del(f.PageQueryKey)
+	var labels []string
+	for _, l := range q[f.LabelQueryKey] {
+		if strings.EqualFold(l, facet.Label.Name) {
+			continue
+		}
+		labels = append(labels, l)
+	}
+	if !facet.Selected {
+		labels = append(labels, facet.Label.Name)
+	}
+	q[f.LabelQueryKey] = labels
+	if len(labels) == 0 {
+		q.Del(f.LabelQueryKey)
+	}
+	return (&url.URL{
+		Path:     f.Path,
+		RawQuery: q.Encode(),
+	}).String()
+}
@@ -0,0 +1,42 @@
+package component
+
+import (
+	"github.com/shurcooL/htmlg"
+	"github.com/shurcooL/octicon"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PartialFailure is a component that displays a warning about optional
+// parts of the page that failed to load. It renders nothing if there are no failures.
+type PartialFailure struct {
+	Failures []string // Descriptions of failures.
+}
+
+func (f PartialFailure) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <div class="partial-failure">
+	// 	<span style="margin-right: 6px;">{{octicon "alert"}}</span>Some parts of this page couldn't be loaded.
+	// 	<ul>{{range .Failures}}<li>{{.}}</li>{{end}}</ul>
+	// </div>
+	if len(f.Failures) == 0 {
+		return nil
+	}
+	icon := &html.Node{
+		Type: html.ElementNode, Data: atom.Span.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Style.String(), Val: "margin-right: 6px;"},
+		},
+		FirstChild: octicon.Alert(),
+	}
+	ul := htmlg.UL()
+	for _, failure := range f.Failures {
+		ul.AppendChild(htmlg.LI(htmlg.Text(failure)))
+	}
+	div := htmlg.DivClass("partial-failure",
+		icon,
+		htmlg.Text("Some parts of this page couldn't be loaded."),
+		ul,
+	)
+	return []*html.Node{div}
+}
@@ -0,0 +1,110 @@
+package component
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/shurcooL/htmlg"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Pagination is a component that displays links for navigating
+// between pages of a list. It renders nothing if there's only one page.
+//
+// If the total number of pages is unknown, PageCount is 0, and only
+// links to the previous and next pages are displayed.
+type Pagination struct {
+	Page         int        // Current page, 1-based.
+	PageCount    int        // Total number of pages, or 0 if unknown.
+	HasNext      bool       // Whether there's a next page. Only used if PageCount is unknown.
+	Path         string     // URL path of current page (needed to generate correct links).
+	Query        url.Values // URL query of current page (needed to generate correct links).
+	PageQueryKey string     // Name of query key for controlling current page. Constant, but provided externally.
+}
+
+func (p Pagination) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <div class="pagination">
+	// 	<a href="...">Previous</a>
+	// 	<a href="...">1</a> <span class="gap">…</span> <a href="...">4</a> <em class="current">5</em> <a href="...">6</a> …
+	// 	<a href="...">Next</a>
+	// </div>
+	unknown := p.PageCount == 0
+	if (!unknown && p.PageCount <= 1) || (unknown && p.Page == 1 && !p.HasNext) {
+		return nil
+	}
+	div := htmlg.DivClass("pagination")
+	if p.Page > 1 {
+		div.AppendChild(p.link(p.Page-1, "‹ Previous"))
+	} else {
+		div.AppendChild(htmlg.SpanClass("disabled", htmlg.Text("‹ Previous")))
+	}
+	if unknown {
+		div.AppendChild(&html.Node{
+			Type: html.ElementNode, Data: atom.Em.String(),
+			Attr:       []html.Attribute{{Key: atom.Class.String(), Val: "current"}},
+			FirstChild: htmlg.Text(fmt.Sprint(p.Page)),
+		})
+		if p.HasNext {
+			div.AppendChild(p.link(p.Page+1, "Next ›"))
+		} else {
+			div.AppendChild(htmlg.SpanClass("disabled", htmlg.Text("Next ›")))
+		}
+		return []*html.Node{div}
+	}
+	last := 0
+	for _, page := range p.pages() {
+		if page > last+1 {
+			div.AppendChild(htmlg.SpanClass("gap", htmlg.Text("…")))
+		}
+		if page == p.Page {
+			div.AppendChild(&html.Node{
+				Type: html.ElementNode, Data: atom.Em.String(),
+				Attr:       []html.Attribute{{Key: atom.Class.String(), Val: "current"}},
+				FirstChild: htmlg.Text(fmt.Sprint(page)),
+			})
+		} else {
+			div.AppendChild(p.link(page, fmt.Sprint(page)))
+		}
+		last = page
+	}
+	if p.Page < p.PageCount {
+		div.AppendChild(p.link(p.Page+1, "Next ›"))
+	} else {
+		div.AppendChild(htmlg.SpanClass("disabled", htmlg.Text("Next ›")))
+	}
+	return []*html.Node{div}
+}
+
+// pages returns the sorted page numbers to display links for.
+// It includes the first and last pages, and a few pages around the current one.
+func (p Pagination) pages() []int {
+	const around = 2 // Number of pages to display on each side of current page.
+	var pages []int
+	for page := 1; page <= p.PageCount; page++ {
+		if page == 1 || page == p.PageCount || (page >= p.Page-around && page <= p.Page+around) {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+// link returns a link to page with the given text.
+func (p Pagination) link(page int, text string) *html.Node {
+	q := copyQuery(p.Query)
+	if page == 1 {
+		q.Del(p.PageQueryKey)
+	} else {
+		q.Set(p.PageQueryKey, fmt.Sprint(page))
+	}
+	pageURL := (&url.URL{
+		Path:     p.Path,
+		RawQuery: q.Encode(),
+	}).String()
+	return &html.Node{
+		Type: html.ElementNode, Data: atom.A.String(),
+		Attr:       []html.Attribute{{Key: atom.Href.String(), Val: pageURL}},
+		FirstChild: htmlg.Text(text),
+	}
+}
@@ -0,0 +1,86 @@
+package component
+
+import (
+	"fmt"
+	"time"
+
+	"dmitri.shuralyov.com/state"
+	"github.com/shurcooL/htmlg"
+	"github.com/shurcooL/octicon"
+	"github.com/shurcooL/users"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ReviewerVote is the latest vote of a reviewer on a change.
+type ReviewerVote struct {
+	User  users.User
+	State state.Review // Never state.ReviewNoScore.
+	At    time.Time
+}
+
+// ReviewSummary is a component that summarizes the latest votes of reviewers of a change,
+// and whether the change has the approvals it needs.
+type ReviewSummary struct {
+	Votes       []ReviewerVote
+	Approved    bool
+	Explanation string // Short explanation of the approval status, e.g., "Needs a +2 review".
+}
+
+func (s ReviewSummary) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <div class="review-summary list-entry-border">
+	// 	<div class="review-summary-status {{if .Approved}}approved{{else}}pending{{end}}">{{octicon}} {{.Explanation}}</div>
+	// 	<ul>
+	// 		{{range .Votes}}<li class="{{if .Blocking}}blocking{{end}}">{{render (avatar .User)}} {{render (user .User)}} <span class="vote">{{.State}}</span></li>{{end}}
+	// 	</ul>
+	// </div>
+	statusClass, icon := "review-summary-status pending", octicon.PrimitiveDot()
+	if s.Approved {
+		statusClass, icon = "review-summary-status approved", octicon.Check()
+	}
+	status := htmlg.DivClass(statusClass,
+		&html.Node{
+			Type: html.ElementNode, Data: atom.Span.String(),
+			Attr: []html.Attribute{
+				{Key: atom.Style.String(), Val: "margin-right: 6px;"},
+			},
+			FirstChild: icon,
+		},
+		htmlg.Text(s.Explanation),
+	)
+	ul := htmlg.UL()
+	for _, v := range s.Votes {
+		li := htmlg.LI()
+		htmlg.AppendChildren(li, Avatar{User: v.User, Size: 20, inline: true}.Render()...)
+		htmlg.AppendChildren(li, User{v.User}.Render()...)
+		li.AppendChild(htmlg.SpanClass(voteClass(v.State), htmlg.Text(voteText(v.State))))
+		if v.State == state.ReviewMinus2 {
+			li.Attr = append(li.Attr, html.Attribute{Key: atom.Class.String(), Val: "blocking"})
+			li.Attr = append(li.Attr, html.Attribute{Key: atom.Title.String(), Val: v.User.Login + "'s −2 vote blocks this change."})
+		}
+		ul.AppendChild(li)
+	}
+	div := htmlg.DivClass("review-summary list-entry-border", status, ul)
+	return []*html.Node{div}
+}
+
+// voteClass returns the CSS class of a vote with review state r.
+func voteClass(r state.Review) string {
+	switch {
+	case r > 0:
+		return "vote positive"
+	case r < 0:
+		return "vote negative"
+	default:
+		return "vote"
+	}
+}
+
+// voteText returns the text of a vote with review state r, e.g., "+2" or "−1".
+func voteText(r state.Review) string {
+	if r < 0 {
+		return fmt.Sprintf("−%d", -r) // Use a minus sign rather than a hyphen.
+	}
+	return fmt.Sprintf("%+d", r)
+}
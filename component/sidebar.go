@@ -0,0 +1,138 @@
+package component
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"dmitri.shuralyov.com/html/belt"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/htmlg"
+	issuescomponent "github.com/shurcooL/issuesapp/component"
+	"github.com/shurcooL/users"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ChangeSidebar is a component that displays metadata of a change.
+// Reviewers, labels and participants link to the list of changes filtered by them.
+type ChangeSidebar struct {
+	Change       change.Change
+	Reviewers    []users.User // Users whose review is requested.
+	Participants []users.User // Users who participated in the change, starting with its author.
+	BaseRef      string       // Name of the ref that the change is to be merged into. Empty if unknown.
+	HeadRef      string       // Name of the ref with the changes. Empty if unknown.
+
+	ChangeURL      string // URL of the change (needed to generate correct links).
+	ListURL        string // URL of the list of changes (needed to generate correct links).
+	SearchQueryKey string // Name of query key for search query. Constant, but provided externally.
+	LabelQueryKey  string // Name of query key for selected labels. Constant, but provided externally.
+}
+
+func (s ChangeSidebar) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <aside class="change-sidebar">
+	// 	<div class="sidebar-section">
+	// 		<div class="sidebar-heading">Reviewers</div>
+	// 		{{range .Reviewers}}<a href="...">{{render (avatar .)}} {{.Login}}</a>{{else}}<div class="gray tiny">None requested.</div>{{end}}
+	// 	</div>
+	// 	...
+	// </aside>
+	aside := &html.Node{
+		Type: html.ElementNode, Data: atom.Aside.String(),
+		Attr: []html.Attribute{{Key: atom.Class.String(), Val: "change-sidebar"}},
+	}
+
+	reviewers := section("Reviewers")
+	for _, u := range s.Reviewers {
+		reviewers.AppendChild(s.userLink(u, "reviewer", fmt.Sprintf("Show changes reviewed by, or awaiting a review from, %s", u.Login)))
+	}
+	if len(s.Reviewers) == 0 {
+		reviewers.AppendChild(htmlg.DivClass("gray tiny", htmlg.Text("None requested.")))
+	}
+	aside.AppendChild(reviewers)
+
+	labels := section("Labels")
+	for _, l := range s.Change.Labels {
+		a := link(s.ListURL+"?"+url.Values{s.LabelQueryKey: {l.Name}}.Encode(), fmt.Sprintf("Show changes labeled %q", l.Name))
+		htmlg.AppendChildren(a, issuescomponent.Label{Label: l}.Render()...)
+		labels.AppendChild(a)
+	}
+	if len(s.Change.Labels) == 0 {
+		labels.AppendChild(htmlg.DivClass("gray tiny", htmlg.Text("None yet.")))
+	}
+	aside.AppendChild(labels)
+
+	contents := section("Contents")
+	contents.AppendChild(link(s.ChangeURL+"/commits", "", htmlg.Text(plural(s.Change.Commits, "commit"))))
+	contents.AppendChild(link(s.ChangeURL+"/files", "", htmlg.Text(plural(s.Change.ChangedFiles, "changed file"))))
+	aside.AppendChild(contents)
+
+	if s.BaseRef != "" || s.HeadRef != "" {
+		refs := section("Refs")
+		if s.BaseRef != "" {
+			div := htmlg.Div(htmlg.Text("base "))
+			htmlg.AppendChildren(div, belt.Reference{Name: s.BaseRef}.Render()...)
+			refs.AppendChild(div)
+		}
+		if s.HeadRef != "" {
+			div := htmlg.Div(htmlg.Text("head "))
+			htmlg.AppendChildren(div, belt.Reference{Name: s.HeadRef}.Render()...)
+			refs.AppendChild(div)
+		}
+		aside.AppendChild(refs)
+	}
+
+	participants := section(plural(len(s.Participants), "participant"))
+	for _, u := range s.Participants {
+		participants.AppendChild(s.userLink(u, "author", fmt.Sprintf("Show changes opened by %s", u.Login)))
+	}
+	aside.AppendChild(participants)
+
+	return []*html.Node{aside}
+}
+
+// userLink returns a link to the list of changes matching the search qualifier with the login of u.
+func (s ChangeSidebar) userLink(u users.User, qualifier, title string) *html.Node {
+	a := link(s.ListURL+"?"+url.Values{s.SearchQueryKey: {qualifier + ":" + quote(u.Login)}}.Encode(), title)
+	htmlg.AppendChildren(a, Avatar{User: u, Size: 20, inline: true}.Render()...)
+	a.AppendChild(htmlg.Text(u.Login))
+	return a
+}
+
+// section returns a sidebar section with the given heading.
+func section(heading string) *html.Node {
+	return htmlg.DivClass("sidebar-section", htmlg.DivClass("sidebar-heading", htmlg.Text(heading)))
+}
+
+// link returns a sidebar link to href with an optional title.
+func link(href, title string, nodes ...*html.Node) *html.Node {
+	a := &html.Node{
+		Type: html.ElementNode, Data: atom.A.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Class.String(), Val: "sidebar-link"},
+			{Key: atom.Href.String(), Val: href},
+		},
+	}
+	if title != "" {
+		a.Attr = append(a.Attr, html.Attribute{Key: atom.Title.String(), Val: title})
+	}
+	htmlg.AppendChildren(a, nodes...)
+	return a
+}
+
+// quote quotes a search qualifier value if it contains spaces.
+func quote(v string) string {
+	if strings.ContainsAny(v, " \t") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// plural returns the count n followed by noun, pluralized if needed.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
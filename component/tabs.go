@@ -3,7 +3,9 @@ package component
 import (
 	"fmt"
 	"net/url"
+	"sort"
 
+	"dmitri.shuralyov.com/app/changes/route"
 	"github.com/shurcooL/htmlg"
 	"github.com/shurcooL/octicon"
 	"golang.org/x/net/html"
@@ -11,43 +13,138 @@ import (
 )
 
 // ChangesNav is a navigation component for displaying a header for a list of changes.
-// It contains tabs to switch between viewing open and closed changes.
+// It contains tabs to switch between viewing open, closed, merged, all and unread changes,
+// and a search box for searching changes.
 type ChangesNav struct {
-	OpenCount     uint64     // Open changes count.
-	ClosedCount   uint64     // Closed changes count.
-	Path          string     // URL path of current page (needed to generate correct links).
-	Query         url.Values // URL query of current page (needed to generate correct links).
-	StateQueryKey string     // Name of query key for controlling change state filter. Constant, but provided externally.
+	// Counts maps the "is:" value of each state tab to the count of changes in it
+	// (see route.StateTab.Is). Counts that couldn't be fetched are missing.
+	Counts map[string]uint64
+
+	NoMergedCount bool // Whether the change service can't count merged changes, so the Merged tab has no count.
+
+	UnreadTab bool // Whether to display the unread tab, which requires notifications.
+
+	Path           string     // URL path of current page (needed to generate correct links).
+	Query          url.Values // URL query of current page (needed to generate correct links).
+	PageQueryKey   string     // Name of query key for controlling current page. Constant, but provided externally.
+	SearchQueryKey string     // Name of query key for search query. Constant, but provided externally.
+	SortQueryKey   string     // Name of query key for controlling sort order. Constant, but provided externally.
+	SortOptions    []SortOption
+}
+
+// SortOption is an option for sorting a list of changes.
+type SortOption struct {
+	Value string // Value of sort query key.
+	Name  string // Human-readable name.
 }
 
 func (n ChangesNav) Render() []*html.Node {
 	// TODO: Make this much nicer.
-	// <header class="list-entry-header">
-	// 	<nav>{{.Tabs}}</nav>
+	// <header class="list-entry-header" style="display: flex;">
+	// 	<nav style="flex-grow: 1;">{{.Tabs}}</nav>
+	// 	{{.Search}}
 	// </header>
-	nav := &html.Node{Type: html.ElementNode, Data: atom.Nav.String()}
+	nav := &html.Node{
+		Type: html.ElementNode, Data: atom.Nav.String(),
+		Attr: []html.Attribute{{Key: atom.Style.String(), Val: "flex-grow: 1;"}},
+	}
 	htmlg.AppendChildren(nav, n.tabs()...)
 	header := &html.Node{
 		Type: html.ElementNode, Data: atom.Header.String(),
-		Attr:       []html.Attribute{{Key: atom.Class.String(), Val: "list-entry-header"}},
-		FirstChild: nav,
+		Attr: []html.Attribute{
+			{Key: atom.Class.String(), Val: "list-entry-header"},
+			{Key: atom.Style.String(), Val: "display: flex; align-items: center;"},
+		},
 	}
+	header.AppendChild(nav)
+	header.AppendChild(n.search())
 	return []*html.Node{header}
 }
 
+// search renders the HTML form for searching and sorting changes.
+// Other parameters of the current query, except the page, are preserved.
+func (n ChangesNav) search() *html.Node {
+	form := &html.Node{
+		Type: html.ElementNode, Data: atom.Form.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Class.String(), Val: "changes-search"},
+			{Key: atom.Method.String(), Val: "get"},
+			{Key: atom.Action.String(), Val: n.Path},
+		},
+	}
+	var keys []string
+	for k := range n.Query {
+		if k == n.SearchQueryKey || k == n.SortQueryKey || k == n.PageQueryKey {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range n.Query[k] {
+			form.AppendChild(&html.Node{
+				Type: html.ElementNode, Data: atom.Input.String(),
+				Attr: []html.Attribute{
+					{Key: atom.Type.String(), Val: "hidden"},
+					{Key: atom.Name.String(), Val: k},
+					{Key: atom.Value.String(), Val: v},
+				},
+			})
+		}
+	}
+	form.AppendChild(&html.Node{
+		Type: html.ElementNode, Data: atom.Input.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Type.String(), Val: "search"},
+			{Key: atom.Name.String(), Val: n.SearchQueryKey},
+			{Key: atom.Value.String(), Val: n.Query.Get(n.SearchQueryKey)},
+			{Key: atom.Placeholder.String(), Val: `Search, e.g., is:merged author:gopher label:"NeedsFix"`},
+			{Key: atom.Title.String(), Val: "Supported qualifiers: is:open, is:closed, is:merged, is:unread, author:, label:, reviewer:, created:>YYYY-MM-DD."},
+		},
+	})
+	form.AppendChild(n.sortSelect())
+	return form
+}
+
+// sortSelect renders the HTML <select> element for choosing the sort order.
+// Selecting an option submits the search form.
+func (n ChangesNav) sortSelect() *html.Node {
+	selectedSort := n.Query.Get(n.SortQueryKey)
+	sel := &html.Node{
+		Type: html.ElementNode, Data: atom.Select.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Name.String(), Val: n.SortQueryKey},
+			{Key: atom.Title.String(), Val: "Sort"},
+			{Key: atom.Onchange.String(), Val: "this.form.submit();"},
+		},
+	}
+	sel.AppendChild(&html.Node{
+		Type: html.ElementNode, Data: atom.Option.String(),
+		Attr:       []html.Attribute{{Key: atom.Value.String(), Val: ""}},
+		FirstChild: htmlg.Text("Sort: Default"),
+	})
+	for _, o := range n.SortOptions {
+		option := &html.Node{
+			Type: html.ElementNode, Data: atom.Option.String(),
+			Attr:       []html.Attribute{{Key: atom.Value.String(), Val: o.Value}},
+			FirstChild: htmlg.Text("Sort: " + o.Name),
+		}
+		if o.Value == selectedSort {
+			option.Attr = append(option.Attr, html.Attribute{Key: atom.Selected.String(), Val: ""})
+		}
+		sel.AppendChild(option)
+	}
+	return sel
+}
+
 // tabs renders the HTML nodes for <nav> element with tab header links.
 func (n ChangesNav) tabs() []*html.Node {
-	selectedTabName := n.Query.Get(n.StateQueryKey)
+	selectedTabName := n.Query.Get(route.StateQueryKey)
 	var ns []*html.Node
-	for i, tab := range []struct {
-		Name      string // Tab name corresponds to its state filter query value.
-		Component htmlg.Component
-	}{
-		// Note: The routing logic (i.e., exact tab Name values) is duplicated with tabStateFilter.
-		//       Might want to try to factor it out into a common location (e.g., a route package or so).
-		{Name: "", Component: OpenChangesTab{Count: n.OpenCount}},
-		{Name: "closed", Component: ClosedChangesTab{Count: n.ClosedCount}},
-	} {
+	for i, tab := range route.StateTabs {
+		if tab.Is == "unread" && !n.UnreadTab {
+			continue
+		}
 		tabURL := (&url.URL{
 			Path:     n.Path,
 			RawQuery: n.rawQuery(tab.Name),
@@ -64,32 +161,52 @@ func (n ChangesNav) tabs() []*html.Node {
 		if i > 0 {
 			a.Attr = append(a.Attr, html.Attribute{Key: atom.Style.String(), Val: "margin-left: 12px;"})
 		}
-		htmlg.AppendChildren(a, tab.Component.Render()...)
+		htmlg.AppendChildren(a, n.tabComponent(tab).Render()...)
 		ns = append(ns, a)
 	}
 	return ns
 }
 
+// tabComponent returns the component that displays the header of tab.
+func (n ChangesNav) tabComponent(tab route.StateTab) htmlg.Component {
+	count, ok := n.Counts[tab.Is]
+	switch tab.Is {
+	case "open":
+		return OpenChangesTab{Count: count, Unavailable: !ok}
+	case "closed":
+		return ClosedChangesTab{Count: count, Unavailable: !ok}
+	case "merged":
+		return MergedChangesTab{Count: count, Unavailable: !ok && !n.NoMergedCount, NoCount: n.NoMergedCount}
+	case "unread":
+		return UnreadChangesTab{Count: count, Unavailable: !ok}
+	default:
+		return AllChangesTab{Count: count, Unavailable: !ok}
+	}
+}
+
 // rawQuery returns the raw query for a link pointing to tabName.
+// Switching tabs starts over from the first page.
 func (n ChangesNav) rawQuery(tabName string) string {
-	q := n.Query
+	q := copyQuery(n.Query)
+	q.Del(n.PageQueryKey)
 	if tabName == "" {
-		q.Del(n.StateQueryKey)
+		q.Del(route.StateQueryKey)
 		return q.Encode()
 	}
-	q.Set(n.StateQueryKey, tabName)
+	q.Set(route.StateQueryKey, tabName)
 	return q.Encode()
 }
 
 // OpenChangesTab is an "Open Changes Tab" component.
 type OpenChangesTab struct {
-	Count uint64 // Count of open changes.
+	Count       uint64 // Count of open changes.
+	Unavailable bool   // Count couldn't be fetched.
 }
 
 func (t OpenChangesTab) Render() []*html.Node {
 	// TODO: Make this much nicer.
 	// <span style="margin-right: 4px;">{{octicon "git-pull-request"}}</span>
-	// {{.Count}} Open
+	// {{if .Unavailable}}?{{else}}{{.Count}}{{end}} Open
 	icon := &html.Node{
 		Type: html.ElementNode, Data: atom.Span.String(),
 		Attr: []html.Attribute{
@@ -97,19 +214,20 @@ func (t OpenChangesTab) Render() []*html.Node {
 		},
 		FirstChild: octicon.GitPullRequest(),
 	}
-	text := htmlg.Text(fmt.Sprintf("%d Open", t.Count))
+	text := htmlg.Text(tabText(t.Count, t.Unavailable, "Open"))
 	return []*html.Node{icon, text}
 }
 
 // ClosedChangesTab is a "Closed Changes Tab" component.
 type ClosedChangesTab struct {
-	Count uint64 // Count of closed changes.
+	Count       uint64 // Count of closed changes.
+	Unavailable bool   // Count couldn't be fetched.
 }
 
 func (t ClosedChangesTab) Render() []*html.Node {
 	// TODO: Make this much nicer.
 	// <span style="margin-right: 4px;">{{octicon "check"}}</span>
-	// {{.Count}} Closed
+	// {{if .Unavailable}}?{{else}}{{.Count}}{{end}} Closed
 	icon := &html.Node{
 		Type: html.ElementNode, Data: atom.Span.String(),
 		Attr: []html.Attribute{
@@ -117,6 +235,83 @@ func (t ClosedChangesTab) Render() []*html.Node {
 		},
 		FirstChild: octicon.Check(),
 	}
-	text := htmlg.Text(fmt.Sprintf("%d Closed", t.Count))
+	text := htmlg.Text(tabText(t.Count, t.Unavailable, "Closed"))
 	return []*html.Node{icon, text}
 }
+
+// MergedChangesTab is a "Merged Changes Tab" component.
+type MergedChangesTab struct {
+	Count       uint64 // Count of merged changes.
+	Unavailable bool   // Count couldn't be fetched.
+	NoCount     bool   // The change service can't count merged changes, so no count is displayed.
+}
+
+func (t MergedChangesTab) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <span style="margin-right: 4px;">{{octicon "git-merge"}}</span>
+	// {{if .NoCount}}{{else if .Unavailable}}? {{else}}{{.Count}} {{end}}Merged
+	icon := &html.Node{
+		Type: html.ElementNode, Data: atom.Span.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Style.String(), Val: "margin-right: 4px;"},
+		},
+		FirstChild: octicon.GitMerge(),
+	}
+	if t.NoCount {
+		return []*html.Node{icon, htmlg.Text("Merged")}
+	}
+	text := htmlg.Text(tabText(t.Count, t.Unavailable, "Merged"))
+	return []*html.Node{icon, text}
+}
+
+// AllChangesTab is an "All Changes Tab" component.
+type AllChangesTab struct {
+	Count       uint64 // Count of all changes.
+	Unavailable bool   // Count couldn't be fetched.
+}
+
+func (t AllChangesTab) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// {{if .Unavailable}}?{{else}}{{.Count}}{{end}} All
+	return []*html.Node{htmlg.Text(tabText(t.Count, t.Unavailable, "All"))}
+}
+
+// copyQuery returns a copy of query q, so that it can be modified
+// without affecting the original.
+func copyQuery(q url.Values) url.Values {
+	c := make(url.Values, len(q))
+	for k, v := range q {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// UnreadChangesTab is an "Unread Changes Tab" component.
+type UnreadChangesTab struct {
+	Count       uint64 // Count of unread changes.
+	Unavailable bool   // Count couldn't be fetched.
+}
+
+func (t UnreadChangesTab) Render() []*html.Node {
+	// TODO: Make this much nicer.
+	// <span style="margin-right: 4px;">{{octicon "bell"}}</span>
+	// {{if .Unavailable}}?{{else}}{{.Count}}{{end}} Unread
+	icon := &html.Node{
+		Type: html.ElementNode, Data: atom.Span.String(),
+		Attr: []html.Attribute{
+			{Key: atom.Style.String(), Val: "margin-right: 4px;"},
+		},
+		FirstChild: octicon.Bell(),
+	}
+	text := htmlg.Text(tabText(t.Count, t.Unavailable, "Unread"))
+	return []*html.Node{icon, text}
+}
+
+// tabText returns the text of a tab with the given count and name.
+// A count that couldn't be fetched is displayed as a question mark.
+func tabText(count uint64, unavailable bool, name string) string {
+	if unavailable {
+		return "? " + name
+	}
+	return fmt.Sprintf("%d %s", count, name)
+}
@@ -0,0 +1,44 @@
+package component
+
+import (
+	"testing"
+
+	"dmitri.shuralyov.com/app/changes/route"
+	"golang.org/x/net/html"
+)
+
+// text returns the text content of nodes.
+func text(nodes []*html.Node) string {
+	var s string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			s += n.Data
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return s
+}
+
+func TestMergedTab(t *testing.T) {
+	merged := route.StateTab{Name: "merged", Is: "merged"}
+	tests := []struct {
+		name string
+		nav  ChangesNav
+		want string
+	}{
+		{name: "counted", nav: ChangesNav{Counts: map[string]uint64{"merged": 7}}, want: "7 Merged"},
+		{name: "failed to count", nav: ChangesNav{}, want: "? Merged"},
+		{name: "can't count", nav: ChangesNav{NoMergedCount: true}, want: "Merged"},
+	}
+	for _, tc := range tests {
+		if got := text(tc.nav.tabComponent(merged).Render()); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
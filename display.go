@@ -65,6 +65,33 @@ func (i timelineItem) ID() string {
 	}
 }
 
+// Anchors returns IDs of elements that the timeline item is rendered with,
+// which can be targeted by URL fragments.
+func (i timelineItem) Anchors() []string {
+	switch i := i.TimelineItem.(type) {
+	case change.Comment:
+		return []string{"comment-" + i.ID}
+	case change.Review:
+		anchors := []string{"comment-" + i.ID}
+		for _, c := range i.Comments {
+			anchors = append(anchors, "comment-"+c.ID, "thread-"+c.ID)
+		}
+		return anchors
+	default:
+		return nil
+	}
+}
+
+// hiddenTimeline is a collapsed range of timeline items, for display purposes.
+type hiddenTimeline struct {
+	From, To int      // Indices of the hidden items in the timeline, [From, To).
+	Anchors  []string // Anchors of the hidden items.
+	URL      string   // URL of the fragment with the hidden items.
+}
+
+// Count returns the number of hidden items.
+func (h hiddenTimeline) Count() int { return h.To - h.From }
+
 // byCreatedAtID implements sort.Interface.
 type byCreatedAtID []timelineItem
 
@@ -84,7 +111,8 @@ func (s byCreatedAtID) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 //
 // http://primercss.io/nav/#tabnav
 type tabnav struct {
-	Tabs []tab
+	Tabs  []tab
+	Extra htmlg.Component // Optional content displayed on the right side.
 }
 
 func (t tabnav) Render() []*html.Node {
@@ -95,7 +123,12 @@ func (t tabnav) Render() []*html.Node {
 	for _, t := range t.Tabs {
 		htmlg.AppendChildren(nav, t.Render()...)
 	}
-	return []*html.Node{htmlg.DivClass("tabnav", nav)}
+	div := htmlg.DivClass("tabnav")
+	if t.Extra != nil {
+		htmlg.AppendChildren(div, htmlg.DivClass("tabnav-extra", t.Extra.Render()...))
+	}
+	htmlg.AppendChildren(div, nav)
+	return []*html.Node{div}
 }
 
 // tab is a single tab entry within a tabnav.
@@ -175,6 +208,18 @@ func (c commitMessage) Time() template.HTML {
 // fileDiff represents a file diff for display purposes.
 type fileDiff struct {
 	*diff.FileDiff
+
+	Threads []*inlineThread // Threads of inline comments on the file, sorted by creation time.
+	Split   bool            // Whether to lay out the old and new files side by side.
+}
+
+// reviewComment is an inline comment of a review, for display purposes.
+type reviewComment struct {
+	change.InlineComment
+
+	ReviewID  string
+	User      users.User
+	CreatedAt time.Time
 }
 
 func (f fileDiff) Title() (template.HTML, error) {
@@ -194,19 +239,251 @@ func (f fileDiff) Title() (template.HTML, error) {
 	}
 }
 
-func (f fileDiff) Diff() (template.HTML, error) {
+// Path returns the path of the file, without the "a/" or "b/" prefix.
+// It's the new path, unless the file was removed.
+func (f fileDiff) Path() string {
+	if new := strings.TrimPrefix(f.NewName, "b/"); new != "/dev/null" {
+		return new
+	}
+	return strings.TrimPrefix(f.OrigName, "a/")
+}
+
+// diffLine is a single line of a file diff, for display purposes.
+type diffLine struct {
+	Type    string        // One of "hunk", "context", "added", "deleted", "nonewline".
+	OldLine int           // Line number in the old file, or 0 if not applicable.
+	NewLine int           // Line number in the new file, or 0 if not applicable.
+	HTML    template.HTML // Highlighted contents of the line, including the leading ' ', '+' or '-'.
+
+	Threads []*inlineThread // Threads of inline comments on the line.
+}
+
+// Anchor returns the ID of the element for line l of file at path,
+// or empty string if the line doesn't have one.
+// Lines in the new file are "{path}-R{line}", and deleted lines are "{path}-L{line}".
+func (l diffLine) Anchor(path string) string {
+	switch l.Type {
+	case "context", "added":
+		return fmt.Sprintf("%s-R%d", path, l.NewLine)
+	case "deleted":
+		return fmt.Sprintf("%s-L%d", path, l.OldLine)
+	default:
+		return ""
+	}
+}
+
+// Lines returns the highlighted lines of the file diff, with their line numbers
+// and threads of inline comments.
+func (f fileDiff) Lines() ([]diffLine, error) {
 	hunks, err := diff.PrintHunks(f.Hunks)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	rawLines := strings.Split(strings.TrimSuffix(string(hunks), "\n"), "\n")
+	htmlLines, err := highlightDiffLines(hunks)
+	if err == nil && len(htmlLines) != len(rawLines) {
+		err = fmt.Errorf("got %d highlighted lines, want %d", len(htmlLines), len(rawLines))
 	}
-	html, err := highlightDiff(hunks)
 	if err != nil {
-		log.Println("fileDiff.Diff: highlightDiff:", err)
-		var buf bytes.Buffer
-		template.HTMLEscape(&buf, hunks)
-		html = buf.Bytes()
+		log.Println("fileDiff.Lines: highlightDiffLines:", err)
+		htmlLines = make([]template.HTML, len(rawLines))
+		for i, l := range rawLines {
+			htmlLines[i] = template.HTML(template.HTMLEscapeString(l))
+		}
+	}
+
+	var (
+		lines    []diffLine
+		hunk     int // Index of the next hunk.
+		old, new int // Next line numbers in old and new files.
+	)
+	for i, raw := range rawLines {
+		l := diffLine{HTML: htmlLines[i]}
+		switch {
+		case strings.HasPrefix(raw, "@@"):
+			l.Type = "hunk"
+			if hunk < len(f.Hunks) {
+				old, new = int(f.Hunks[hunk].OrigStartLine), int(f.Hunks[hunk].NewStartLine)
+				hunk++
+			}
+		case strings.HasPrefix(raw, "+"):
+			l.Type, l.NewLine = "added", new
+			new++
+		case strings.HasPrefix(raw, "-"):
+			l.Type, l.OldLine = "deleted", old
+			old++
+		case strings.HasPrefix(raw, "\\"):
+			l.Type = "nonewline"
+		default:
+			l.Type, l.OldLine, l.NewLine = "context", old, new
+			old++
+			new++
+		}
+		if l.Type == "context" || l.Type == "added" {
+			l.Threads = f.threadsOn(l.NewLine)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// threadsOn returns threads of inline comments on line of the new file.
+func (f fileDiff) threadsOn(line int) []*inlineThread {
+	var ts []*inlineThread
+	for _, t := range f.Threads {
+		if t.Line() == line {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// Outdated returns threads of inline comments on lines of the file that aren't in the diff,
+// so they can't be displayed under their line.
+func (f fileDiff) Outdated() []*inlineThread {
+	if len(f.Threads) == 0 {
+		return nil
+	}
+	inDiff := make(map[int]bool) // Line numbers of the new file that are in the diff.
+	for _, h := range f.Hunks {
+		new := int(h.NewStartLine)
+		for _, l := range bytes.Split(bytes.TrimSuffix(h.Body, []byte("\n")), []byte("\n")) {
+			if len(l) > 0 && (l[0] == '-' || l[0] == '\\') {
+				continue
+			}
+			inDiff[new] = true
+			new++
+		}
+	}
+	var ts []*inlineThread
+	for _, t := range f.Threads {
+		if !inDiff[t.Line()] {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// diffContexts provides code context for inline comments on lines of a change's diff.
+// It highlights each file at most once.
+type diffContexts struct {
+	fileDiffs []*diff.FileDiff
+	lines     map[string][]diffLine // File path -> lines of its diff.
+}
+
+// contextLines is the number of diff lines displayed above a commented line.
+const contextLines = 3
+
+// Context returns the commented line of file, together with up to contextLines
+// lines above it from the same hunk. It returns no lines if the line isn't in the diff.
+func (d *diffContexts) Context(file string, line int) ([]diffLine, error) {
+	lines, ok := d.lines[file]
+	if !ok {
+		for _, f := range d.fileDiffs {
+			fd := fileDiff{FileDiff: f}
+			if fd.Path() != file {
+				continue
+			}
+			var err error
+			lines, err = fd.Lines()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if d.lines == nil {
+			d.lines = make(map[string][]diffLine)
+		}
+		d.lines[file] = lines
+	}
+	for i, l := range lines {
+		if l.NewLine != line || l.Type == "deleted" {
+			continue
+		}
+		start := i
+		for start > 0 && i-start < contextLines && lines[start-1].Type != "hunk" {
+			start--
+		}
+		return lines[start : i+1], nil
+	}
+	return nil, nil
+}
+
+// highlightDiffLines highlights the src diff, returning the annotated HTML of each line.
+// The trailing newline of src, if any, doesn't start a new line.
+func highlightDiffLines(src []byte) ([]template.HTML, error) {
+	highlighted, err := highlightDiff(src)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := splitHTMLLines(highlighted)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasSuffix(src, []byte("\n")) {
+		// The last line is empty, except for elements that span the trailing newline.
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// splitHTMLLines splits the HTML fragment src into lines, such that each line
+// is valid HTML on its own. Elements that span multiple lines are closed
+// at the end of each line, and reopened at the start of the next one.
+func splitHTMLLines(src []byte) ([]template.HTML, error) {
+	nodes, err := html.ParseFragment(bytes.NewReader(src), &html.Node{
+		Type: html.ElementNode, Data: atom.Pre.String(), DataAtom: atom.Pre,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lines []template.HTML
+		buf   bytes.Buffer
+		open  []*html.Node // Elements that are open at the current position.
+	)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			for i, text := range strings.Split(n.Data, "\n") {
+				if i > 0 {
+					// End the current line, and start the next one.
+					for j := len(open) - 1; j >= 0; j-- {
+						buf.WriteString("</" + open[j].Data + ">")
+					}
+					lines = append(lines, template.HTML(buf.String()))
+					buf.Reset()
+					for _, e := range open {
+						writeStartTag(&buf, e)
+					}
+				}
+				buf.WriteString(html.EscapeString(text))
+			}
+		case html.ElementNode:
+			writeStartTag(&buf, n)
+			open = append(open, n)
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			open = open[:len(open)-1]
+			buf.WriteString("</" + n.Data + ">")
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	lines = append(lines, template.HTML(buf.String()))
+	return lines, nil
+}
+
+// writeStartTag writes the start tag of element n to buf.
+func writeStartTag(buf *bytes.Buffer, n *html.Node) {
+	buf.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		buf.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
 	}
-	return template.HTML(html), nil
+	buf.WriteString(">")
 }
 
 // highlightDiff highlights the src diff, returning the annotated HTML.
@@ -0,0 +1,199 @@
+package changes
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"testing"
+
+	"dmitri.shuralyov.com/service/change"
+	"github.com/sourcegraph/go-diff/diff"
+)
+
+func TestSplitHTMLLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []template.HTML
+	}{
+		{in: "", want: []template.HTML{""}},
+		{in: "a\nb", want: []template.HTML{"a", "b"}},
+		{in: "a\n", want: []template.HTML{"a", ""}},
+		{in: "a &lt; b", want: []template.HTML{"a &lt; b"}},
+		{
+			in:   `<span class="c">x` + "\n" + `y</span>z`,
+			want: []template.HTML{`<span class="c">x</span>`, `<span class="c">y</span>z`},
+		},
+		{
+			in: `<span class="a"><b>1` + "\n\n" + `2</b></span>`,
+			want: []template.HTML{
+				`<span class="a"><b>1</b></span>`,
+				`<span class="a"><b></b></span>`,
+				`<span class="a"><b>2</b></span>`,
+			},
+		},
+	}
+	for _, tc := range tests {
+		got, err := splitHTMLLines([]byte(tc.in))
+		if err != nil {
+			t.Errorf("splitHTMLLines(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitHTMLLines(%q):\ngot  %q\nwant %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// parseFileDiff parses a single file diff, failing the test on error.
+func parseFileDiff(t *testing.T, s string) fileDiff {
+	t.Helper()
+	fd, err := diff.ParseFileDiff([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileDiff{FileDiff: fd}
+}
+
+const testDiff = `--- a/f.go
++++ b/f.go
+@@ -1,4 +1,4 @@
+ package f
+-var a = 1
++var a = 2
++var b = 3
+ 
+@@ -10,2 +10,1 @@
+ func f() {}
+-func g() {}
+\ No newline at end of file
+`
+
+func TestFileDiffLines(t *testing.T) {
+	f := parseFileDiff(t, testDiff)
+	lines, err := f.Lines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type line struct {
+		Type             string
+		OldLine, NewLine int
+		Anchor           string
+	}
+	var got []line
+	for _, l := range lines {
+		got = append(got, line{l.Type, l.OldLine, l.NewLine, l.Anchor(f.Path())})
+	}
+	want := []line{
+		{"hunk", 0, 0, ""},
+		{"context", 1, 1, "f.go-R1"},
+		{"deleted", 2, 0, "f.go-L2"},
+		{"added", 0, 2, "f.go-R2"},
+		{"added", 0, 3, "f.go-R3"},
+		{"context", 3, 4, "f.go-R4"},
+		{"hunk", 0, 0, ""},
+		{"context", 10, 10, "f.go-R10"},
+		{"deleted", 11, 0, "f.go-L11"},
+		{"nonewline", 0, 0, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fileDiff.Lines:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestFileDiffPath(t *testing.T) {
+	tests := []struct {
+		orig, new string
+		want      string
+	}{
+		{orig: "a/f.go", new: "b/f.go", want: "f.go"},
+		{orig: "a/old.go", new: "b/new.go", want: "new.go"},
+		{orig: "/dev/null", new: "b/added.go", want: "added.go"},
+		{orig: "a/removed.go", new: "/dev/null", want: "removed.go"},
+	}
+	for _, tc := range tests {
+		f := fileDiff{FileDiff: &diff.FileDiff{OrigName: tc.orig, NewName: tc.new}}
+		if got := f.Path(); got != tc.want {
+			t.Errorf("fileDiff{%q, %q}.Path(): got %q, want %q", tc.orig, tc.new, got, tc.want)
+		}
+	}
+}
+
+func TestFileDiffThreads(t *testing.T) {
+	thread := func(line int) *inlineThread {
+		return &inlineThread{Comments: []reviewComment{{InlineComment: change.InlineComment{ID: fmt.Sprint(line), File: "f.go", Line: line}}}}
+	}
+	f := parseFileDiff(t, testDiff)
+	f.Threads = []*inlineThread{thread(1), thread(2), thread(2), thread(5), thread(10), thread(11)}
+
+	lines, err := f.Lines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int]int) // New line -> number of threads on it.
+	for _, l := range lines {
+		if len(l.Threads) > 0 {
+			got[l.NewLine] += len(l.Threads)
+		}
+	}
+	if want := map[int]int{1: 1, 2: 2, 10: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("threads on lines: got %v, want %v", got, want)
+	}
+
+	var outdated []int
+	for _, th := range f.Outdated() {
+		outdated = append(outdated, th.Line())
+	}
+	if want := []int{5, 11}; !reflect.DeepEqual(outdated, want) {
+		t.Errorf("fileDiff.Outdated: got lines %v, want %v", outdated, want)
+	}
+}
+
+func TestDiffContexts(t *testing.T) {
+	d := diffContexts{fileDiffs: []*diff.FileDiff{parseFileDiff(t, testDiff).FileDiff}}
+	tests := []struct {
+		file string
+		line int
+		want []int // New line numbers of context lines, 0 for deleted lines.
+	}{
+		{file: "f.go", line: 1, want: []int{1}},
+		{file: "f.go", line: 2, want: []int{1, 0, 2}},
+		{file: "f.go", line: 4, want: []int{0, 2, 3, 4}},
+		{file: "f.go", line: 10, want: []int{10}},
+		{file: "f.go", line: 5, want: nil},
+		{file: "other.go", line: 1, want: nil},
+	}
+	for _, tc := range tests {
+		lines, err := d.Context(tc.file, tc.line)
+		if err != nil {
+			t.Errorf("Context(%q, %d): %v", tc.file, tc.line, err)
+			continue
+		}
+		var got []int
+		for _, l := range lines {
+			got = append(got, l.NewLine)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Context(%q, %d): got lines %v, want %v", tc.file, tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestTimelineItemAnchors(t *testing.T) {
+	tests := []struct {
+		item timelineItem
+		want []string
+	}{
+		{item: timelineItem{change.Comment{ID: "1"}}, want: []string{"comment-1"}},
+		{item: timelineItem{change.Review{ID: "2"}}, want: []string{"comment-2"}},
+		{
+			item: timelineItem{change.Review{ID: "3", Comments: []change.InlineComment{{ID: "4"}, {ID: "5"}}}},
+			want: []string{"comment-3", "comment-4", "thread-4", "comment-5", "thread-5"},
+		},
+		{item: timelineItem{change.TimelineItem{ID: "6"}}, want: nil},
+	}
+	for _, tc := range tests {
+		if got := tc.item.Anchors(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%T.Anchors(): got %q, want %q", tc.item.TimelineItem, got, tc.want)
+		}
+	}
+}
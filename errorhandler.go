@@ -104,3 +104,14 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.WroteHeader = true
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Flush flushes buffered data to the client, if the wrapped
+// http.ResponseWriter supports it. It's needed for streaming responses.
+func (rw *responseWriter) Flush() {
+	f, ok := rw.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	rw.WroteHeader = true
+	f.Flush()
+}
@@ -0,0 +1,191 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shurcooL/httperror"
+)
+
+// timelineSubscriber is implemented by change services that can notify
+// about changes to timelines, instead of having them polled.
+type timelineSubscriber interface {
+	// SubscribeTimeline returns a channel that receives a value whenever
+	// the timeline of the specified change changes, until ctx is done.
+	SubscribeTimeline(ctx context.Context, repo string, id uint64) (<-chan struct{}, error)
+}
+
+// When the change service doesn't support subscribing to timelines, they're polled
+// for new items. The poll interval starts at eventsPollInterval, and doubles while
+// the timeline doesn't change, up to eventsMaxPollInterval, so that pages left open
+// on inactive changes don't keep listing their timelines often.
+const (
+	eventsPollInterval    = 10 * time.Second
+	eventsMaxPollInterval = 5 * time.Minute
+)
+
+// EventsHandler is the handler for "/{changeID}/events" endpoint.
+// It streams new timeline items of a change as server-sent events, rendered as HTML.
+// Only items selected by the timeline filter in the URL query are included.
+//
+// Items that the client already has are skipped. Their number is specified by
+// the "after" query parameter, or by the Last-Event-ID header when reconnecting.
+// Each "timeline" event has an ID that is the number of timeline items seen so far,
+// and JSON data with "items" and "tabnav" fields.
+func (h *handler) EventsHandler(w http.ResponseWriter, req *http.Request, changeID uint64) error {
+	if req.Method != http.MethodGet {
+		return httperror.Method{Allowed: []string{http.MethodGet}}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("EventsHandler: streaming isn't supported by http.ResponseWriter")
+	}
+	state, err := h.state(req, changeID)
+	if err != nil {
+		return err
+	}
+	afterParam := req.Header.Get("Last-Event-ID")
+	if afterParam == "" {
+		afterParam = req.URL.Query().Get("after")
+	}
+	after, err := strconv.Atoi(afterParam)
+	if err != nil || after < 0 {
+		return httperror.BadRequest{Err: fmt.Errorf("invalid number of timeline items %q", afterParam)}
+	}
+	state.Filter, err = parseTimelineFilter(req.URL.Query())
+	if err != nil {
+		return httperror.BadRequest{Err: err}
+	}
+
+	var updates <-chan struct{}
+	if ts, ok := h.cs.(timelineSubscriber); ok {
+		updates, err = ts.SubscribeTimeline(req.Context(), state.RepoSpec, state.ChangeID)
+		if err != nil {
+			log.Println("EventsHandler: SubscribeTimeline failed, falling back to polling:", err)
+			updates = nil
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var (
+		seen     map[string]bool // Keys of timeline items the client has.
+		interval = eventsPollInterval
+	)
+	for {
+		seenBefore := len(seen)
+		seen, err = h.sendTimelineEvent(w, req, &state, seen, after)
+		if err != nil {
+			// The response has started, so the error can't be reported to the client.
+			// It'll be retried on the next update.
+			log.Println("EventsHandler:", err)
+		}
+		flusher.Flush()
+
+		var poll <-chan time.Time // Only used if there's no subscription.
+		if updates == nil {
+			switch {
+			case len(seen) > seenBefore:
+				interval = eventsPollInterval
+			case 2*interval < eventsMaxPollInterval:
+				interval *= 2
+			default:
+				interval = eventsMaxPollInterval
+			}
+			poll = time.After(interval)
+		}
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				// The subscription has ended. The client will reconnect.
+				return nil
+			}
+		case <-poll:
+		case <-req.Context().Done():
+			return nil
+		}
+	}
+}
+
+// sendTimelineEvent writes a server-sent event with timeline items of the change in state
+// that aren't in seen, if there are any. If seen is nil, the first after items of the timeline
+// are considered seen. It returns the updated seen items.
+func (h *handler) sendTimelineEvent(w http.ResponseWriter, req *http.Request, state *state, seen map[string]bool, after int) (map[string]bool, error) {
+	ts, err := h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
+	if err != nil {
+		return seen, fmt.Errorf("changes.ListTimeline: %v", err)
+	}
+	var timeline []timelineItem
+	for _, item := range ts {
+		timeline = append(timeline, timelineItem{item})
+	}
+	sort.Sort(byCreatedAtID(timeline))
+	timeline = filterTimeline(timeline, state.Filter, h.isBot)
+	key := func(item timelineItem) string { return item.TemplateName() + "-" + item.ID() }
+	if seen == nil {
+		seen = make(map[string]bool)
+		for i := 0; i < after && i < len(timeline); i++ {
+			seen[key(timeline[i])] = true
+		}
+	}
+	var newItems []timelineItem
+	for _, item := range timeline {
+		if !seen[key(item)] {
+			newItems = append(newItems, item)
+		}
+	}
+	if len(newItems) == 0 {
+		// Send a comment, so that a disconnected client is noticed.
+		_, err := fmt.Fprint(w, ":\n\n")
+		return seen, err
+	}
+
+	// Failures are logged, but there's no page to display them on.
+	state.failures = nil
+
+	// Update the change, so that Tabnav counters are up to date.
+	state.Change, err = h.cs.Get(req.Context(), state.RepoSpec, state.ChangeID)
+	if err != nil {
+		return seen, fmt.Errorf("changes.Get: %v", err)
+	}
+	t, err := h.timelineTemplates(req.Context(), state, ts)
+	if err != nil {
+		return seen, err
+	}
+	var items bytes.Buffer
+	for _, item := range newItems {
+		err := t.ExecuteTemplate(&items, "timeline-item", item)
+		if err != nil {
+			return seen, fmt.Errorf("t.ExecuteTemplate: %v", err)
+		}
+	}
+	data, err := json.Marshal(struct {
+		Items  template.HTML `json:"items"`
+		Tabnav template.HTML `json:"tabnav"`
+	}{
+		Items:  template.HTML(items.String()),
+		Tabnav: state.Tabnav("Discussion"),
+	})
+	if err != nil {
+		return seen, fmt.Errorf("json.Marshal: %v", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: timeline\ndata: %s\n\n", len(timeline), data)
+	if err != nil {
+		return seen, err
+	}
+	for _, item := range newItems {
+		seen[key(item)] = true
+	}
+	return seen, nil
+}
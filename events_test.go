@@ -0,0 +1,59 @@
+package changes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dmitri.shuralyov.com/service/change"
+)
+
+// subscribedService is a change service with empty timelines,
+// whose timeline subscriptions end right away.
+type subscribedService struct {
+	change.Service
+}
+
+func (subscribedService) ListTimeline(context.Context, string, uint64, *change.ListTimelineOptions) ([]interface{}, error) {
+	return nil, nil
+}
+
+func (subscribedService) SubscribeTimeline(context.Context, string, uint64) (<-chan struct{}, error) {
+	updates := make(chan struct{})
+	close(updates)
+	return updates, nil
+}
+
+func TestEventsHandler(t *testing.T) {
+	h := &handler{cs: subscribedService{}}
+	eh := &errorHandler{handler: func(w http.ResponseWriter, req *http.Request) error {
+		ctx := context.WithValue(req.Context(), BaseURIContextKey, "/changes")
+		ctx = context.WithValue(ctx, RepoSpecContextKey, "repo")
+		return h.EventsHandler(w, req.WithContext(ctx), 1)
+	}}
+	w := httptest.NewRecorder()
+	eh.ServeHTTP(w, httptest.NewRequest("GET", "/1/events?after=0", nil))
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d, want %d; body: %q", got, want, w.Body.String())
+	}
+	if got, want := w.Header().Get("Content-Type"), "text/event-stream"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+	if !w.Flushed {
+		t.Error("response wasn't flushed")
+	}
+	if got, want := w.Body.String(), ":\n\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestResponseWriterFlush(t *testing.T) {
+	// Flushing a writer that doesn't support it does nothing.
+	rw := &responseWriter{ResponseWriter: struct{ http.ResponseWriter }{httptest.NewRecorder()}}
+	rw.Flush()
+	if rw.WroteHeader {
+		t.Error("WroteHeader is true after flushing a writer that doesn't support it")
+	}
+}
@@ -0,0 +1,240 @@
+package changes
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"dmitri.shuralyov.com/app/changes/common"
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/httperror"
+	"github.com/shurcooL/users"
+	"golang.org/x/tools/blog/atom"
+)
+
+// feedLength is the maximum number of entries in a feed.
+const feedLength = 50
+
+// ChangesFeedHandler is the handler for "/feed.atom" endpoint.
+// It serves an Atom feed of the most recently created changes
+// matching the state tab, search query and labels in the URL query.
+func (h *handler) ChangesFeedHandler(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return httperror.Method{Allowed: []string{http.MethodGet}}
+	}
+	var (
+		baseURI = req.Context().Value(BaseURIContextKey).(string)
+		repo    = req.Context().Value(RepoSpecContextKey).(string)
+	)
+	_, q, err := listQuery(req.URL.Query())
+	if err != nil {
+		return httperror.BadRequest{Err: err}
+	}
+	if q.Unread() {
+		// Feed readers aren't signed in, so there are no unread changes to list.
+		return httperror.BadRequest{Err: errors.New("is:unread isn't supported in feeds")}
+	}
+	opt, _ := q.ListOptions()
+	cs, err := listMatching(req.Context(), h.cs, repo, opt, q, nil, "newest")
+	if err != nil {
+		return err
+	}
+	if len(cs) > feedLength {
+		cs = cs[:feedLength]
+	}
+
+	feed := atom.Feed{
+		Title: fmt.Sprintf("Changes · %s", repo),
+		ID:    absoluteURL(req, baseURI+"/feed.atom", req.URL.RawQuery),
+		Link: []atom.Link{
+			{Rel: "self", Href: absoluteURL(req, baseURI+"/feed.atom", req.URL.RawQuery)},
+			{Rel: "alternate", Type: "text/html", Href: absoluteURL(req, baseURI, req.URL.RawQuery)},
+		},
+	}
+	var updated time.Time
+	for _, c := range cs {
+		changeURL := absoluteURL(req, fmt.Sprintf("%s/%d", baseURI, c.ID), "")
+		feed.Entry = append(feed.Entry, &atom.Entry{
+			Title:     fmt.Sprintf("%s #%d", c.Title, c.ID),
+			ID:        changeURL,
+			Link:      []atom.Link{{Rel: "alternate", Type: "text/html", Href: changeURL}},
+			Published: atom.Time(c.CreatedAt),
+			Updated:   atom.Time(c.CreatedAt),
+			Author:    feedPerson(c.Author),
+		})
+		if c.CreatedAt.After(updated) {
+			updated = c.CreatedAt
+		}
+	}
+	feed.Updated = feedUpdated(updated)
+	return writeFeed(w, feed)
+}
+
+// ChangeFeedHandler is the handler for "/{changeID}/feed.atom" endpoint.
+// It serves an Atom feed of the most recent comments, reviews and events of a change.
+func (h *handler) ChangeFeedHandler(w http.ResponseWriter, req *http.Request, changeID uint64) error {
+	if req.Method != http.MethodGet {
+		return httperror.Method{Allowed: []string{http.MethodGet}}
+	}
+	var (
+		baseURI = req.Context().Value(BaseURIContextKey).(string)
+		repo    = req.Context().Value(RepoSpecContextKey).(string)
+	)
+	c, err := h.cs.Get(req.Context(), repo, changeID)
+	if err != nil {
+		return err
+	}
+	ts, err := h.cs.ListTimeline(req.Context(), repo, changeID, nil)
+	if err != nil {
+		return fmt.Errorf("changes.ListTimeline: %v", err)
+	}
+	var timeline []timelineItem
+	for _, item := range ts {
+		timeline = append(timeline, timelineItem{item})
+	}
+	sort.Sort(sort.Reverse(byCreatedAtID(timeline)))
+	if len(timeline) > feedLength {
+		timeline = timeline[:feedLength]
+	}
+
+	changeURL := absoluteURL(req, fmt.Sprintf("%s/%d", baseURI, changeID), "")
+	feed := atom.Feed{
+		Title: fmt.Sprintf("%s #%d · %s", c.Title, c.ID, repo),
+		ID:    changeURL + "/feed.atom",
+		Link: []atom.Link{
+			{Rel: "self", Href: changeURL + "/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: changeURL},
+		},
+	}
+	st := common.State{BaseURI: baseURI, RepoSpec: repo, ChangeID: changeID}
+	l := h.linker(req.Context(), st, append([]users.User{c.Author}, timelineAuthors(timeline)...))
+	updated := c.CreatedAt
+	for _, item := range timeline {
+		feed.Entry = append(feed.Entry, feedEntry(l, h.EventRenderers, item, changeURL))
+		if item.CreatedAt().After(updated) {
+			updated = item.CreatedAt()
+		}
+	}
+	feed.Updated = feedUpdated(updated)
+	return writeFeed(w, feed)
+}
+
+// feedEntry returns the feed entry for a timeline item of the change at changeURL.
+// The entry ID is stable, since it's made from the timeline item ID.
+// Markdown is rendered with references linked by l, like it is on the change page.
+func feedEntry(l *linker, rs component.EventRenderers, item timelineItem, changeURL string) *atom.Entry {
+	e := &atom.Entry{
+		ID:        fmt.Sprintf("%s#%s-%s", changeURL, item.TemplateName(), item.ID()),
+		Link:      []atom.Link{{Rel: "alternate", Type: "text/html", Href: changeURL}},
+		Published: atom.Time(item.CreatedAt()),
+		Updated:   atom.Time(item.CreatedAt()),
+	}
+	switch i := item.TimelineItem.(type) {
+	case change.Comment:
+		e.Title = i.User.Login + " commented"
+		e.Link[0].Href = changeURL + "#comment-" + i.ID
+		e.Author = feedPerson(i.User)
+		e.Content = &atom.Text{Type: "html", Body: string(gfm(l, i.Body))}
+	case change.Review:
+		if i.State == 0 {
+			e.Title = i.User.Login + " commented"
+		} else {
+			e.Title = fmt.Sprintf("%s reviewed %+d", i.User.Login, i.State)
+		}
+		e.Link[0].Href = changeURL + "#comment-" + i.ID
+		e.Author = feedPerson(i.User)
+		body := string(gfm(l, i.Body))
+		for _, c := range i.Comments {
+			body += fmt.Sprintf("<p><code>%s:%d</code></p>", template.HTMLEscapeString(c.File), c.Line) + string(gfm(l, c.Body))
+		}
+		e.Content = &atom.Text{Type: "html", Body: body}
+	case change.TimelineItem:
+		e.Title = i.Actor.Login + " " + eventText(rs, i.Payload)
+		e.Author = feedPerson(i.Actor)
+	}
+	return e
+}
+
+// eventText returns a plain text description of an event with the given payload,
+// for use in places where HTML can't be, like feed entry titles.
+// Renderers registered in rs take precedence over built-in descriptions.
+func eventText(rs component.EventRenderers, payload interface{}) string {
+	if text, ok := rs.PlainText(payload); ok {
+		return text
+	}
+	switch p := payload.(type) {
+	case change.ClosedEvent:
+		return "closed this"
+	case change.ReopenedEvent:
+		return "reopened this"
+	case change.RenamedEvent:
+		return fmt.Sprintf("changed the title from %q to %q", p.From, p.To)
+	case change.LabeledEvent:
+		return fmt.Sprintf("added the %q label", p.Label.Name)
+	case change.UnlabeledEvent:
+		return fmt.Sprintf("removed the %q label", p.Label.Name)
+	case change.ReviewRequestedEvent:
+		return "requested a review from " + p.RequestedReviewer.Login
+	case change.ReviewRequestRemovedEvent:
+		return "removed the review request from " + p.RequestedReviewer.Login
+	case change.MergedEvent:
+		commitID := p.CommitID
+		if len(commitID) > 8 {
+			commitID = commitID[:8]
+		}
+		return fmt.Sprintf("merged commit %s into %s", commitID, p.RefName)
+	case change.DeletedEvent:
+		if p.Type == "comment" {
+			return "deleted a comment"
+		}
+		return fmt.Sprintf("deleted the %s %s", p.Name, p.Type)
+	default:
+		return component.EventFallbackText(p)
+	}
+}
+
+func feedPerson(u users.User) *atom.Person {
+	return &atom.Person{Name: u.Login, URI: u.HTMLURL}
+}
+
+// feedUpdated returns the updated time of a feed whose latest entry is at t.
+// Feeds without entries use the current time.
+func feedUpdated(t time.Time) atom.TimeStr {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return atom.Time(t)
+}
+
+// writeFeed writes the Atom feed to w.
+func writeFeed(w http.ResponseWriter, feed atom.Feed) error {
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(feed)
+	if err != nil {
+		return fmt.Errorf("xml.Encode: %v", err)
+	}
+	return nil
+}
+
+// absoluteURL returns the absolute URL with the given path and raw query
+// on the host that served req.
+func absoluteURL(req *http.Request, path, rawQuery string) string {
+	scheme := "https"
+	if req.TLS == nil {
+		scheme = "http"
+	}
+	return (&url.URL{Scheme: scheme, Host: req.Host, Path: path, RawQuery: rawQuery}).String()
+}
@@ -0,0 +1,158 @@
+package changes
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/users"
+)
+
+const (
+	// showQueryKey is name of query key for showing only timeline items of one kind.
+	// It's one of "comments", "reviews", "events".
+	showQueryKey = "show"
+
+	// authorQueryKey is name of query key for showing only timeline items by the user with that login.
+	authorQueryKey = "author"
+
+	// hideQueryKey is name of query key for hiding timeline items.
+	// The only supported value is "bots", which hides items by bots.
+	hideQueryKey = "hide"
+)
+
+// timelineFilter selects timeline items to display. The zero value selects all items.
+type timelineFilter struct {
+	Show     string // Kind of items to show, as returned by timelineItem.Kind. Empty for all kinds.
+	Author   string // Login of the user whose items to show. Empty for all users.
+	HideBots bool   // Hide items by bots.
+}
+
+// parseTimelineFilter parses the timeline filter from query.
+func parseTimelineFilter(query url.Values) (timelineFilter, error) {
+	f := timelineFilter{
+		Show:   query.Get(showQueryKey),
+		Author: query.Get(authorQueryKey),
+	}
+	switch f.Show {
+	case "", "comments", "reviews", "events":
+	default:
+		return timelineFilter{}, fmt.Errorf("unsupported %s value %q", showQueryKey, f.Show)
+	}
+	switch hide := query.Get(hideQueryKey); hide {
+	case "":
+	case "bots":
+		f.HideBots = true
+	default:
+		return timelineFilter{}, fmt.Errorf("unsupported %s value %q", hideQueryKey, hide)
+	}
+	return f, nil
+}
+
+// Query returns the URL query that selects f.
+func (f timelineFilter) Query() url.Values {
+	query := url.Values{}
+	if f.Show != "" {
+		query.Set(showQueryKey, f.Show)
+	}
+	if f.Author != "" {
+		query.Set(authorQueryKey, f.Author)
+	}
+	if f.HideBots {
+		query.Set(hideQueryKey, "bots")
+	}
+	return query
+}
+
+// IsZero reports whether f selects all items.
+func (f timelineFilter) IsZero() bool { return f == timelineFilter{} }
+
+// match reports whether item is selected by f.
+func (f timelineFilter) match(item timelineItem, isBot func(users.User) bool) bool {
+	if f.Show != "" && item.Kind() != f.Show {
+		return false
+	}
+	if f.Author != "" && item.Author().Login != f.Author {
+		return false
+	}
+	if f.HideBots && isBot(item.Author()) {
+		return false
+	}
+	return true
+}
+
+// filterTimeline returns items of timeline that are selected by f.
+func filterTimeline(timeline []timelineItem, f timelineFilter, isBot func(users.User) bool) []timelineItem {
+	if f.IsZero() {
+		return timeline
+	}
+	var filtered []timelineItem
+	for _, item := range timeline {
+		if f.match(item, isBot) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// timelineAuthors returns the authors of timeline items, in order of their first item.
+func timelineAuthors(timeline []timelineItem) []users.User {
+	var (
+		authors []users.User
+		seen    = make(map[users.UserSpec]bool)
+	)
+	for _, item := range timeline {
+		if u := item.Author(); !seen[u.UserSpec] {
+			seen[u.UserSpec] = true
+			authors = append(authors, u)
+		}
+	}
+	return authors
+}
+
+// isBot reports whether user u is a bot, using Options.IsBot if it's set.
+func (h *handler) isBot(u users.User) bool {
+	if h.IsBot != nil {
+		return h.IsBot(u)
+	}
+	return defaultIsBot(u)
+}
+
+// defaultIsBot reports whether user u is a bot, judging by the login.
+// Users whose login ends with "[bot]", like GitHub Apps, are considered bots.
+// Other bots can be identified via Options.IsBot.
+func defaultIsBot(u users.User) bool {
+	return strings.HasSuffix(u.Login, "[bot]")
+}
+
+// Kind returns the kind of the timeline item for filtering purposes.
+// It's one of "comments", "reviews", "events". Reviews without a vote
+// are considered comments.
+func (i timelineItem) Kind() string {
+	switch i.TemplateName() {
+	case "review":
+		if i.TimelineItem.(change.Review).State == 0 {
+			return "comments"
+		}
+		return "reviews"
+	case "event":
+		return "events"
+	default:
+		return "comments"
+	}
+}
+
+// Author returns the user who created the timeline item.
+func (i timelineItem) Author() users.User {
+	switch i := i.TimelineItem.(type) {
+	case change.Comment:
+		return i.User
+	case change.Review:
+		return i.User
+	case change.TimelineItem:
+		return i.Actor
+	default:
+		panic(fmt.Errorf("unknown item type %T", i))
+	}
+}
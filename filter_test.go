@@ -0,0 +1,97 @@
+package changes
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"dmitri.shuralyov.com/service/change"
+	statepkg "dmitri.shuralyov.com/state"
+	"github.com/shurcooL/users"
+)
+
+func TestParseTimelineFilter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    timelineFilter
+		wantErr bool
+	}{
+		{in: "", want: timelineFilter{}},
+		{in: "show=comments", want: timelineFilter{Show: "comments"}},
+		{in: "show=reviews&author=gopher", want: timelineFilter{Show: "reviews", Author: "gopher"}},
+		{in: "show=events&hide=bots", want: timelineFilter{Show: "events", HideBots: true}},
+		{in: "show=commits", wantErr: true},
+		{in: "hide=humans", wantErr: true},
+	}
+	for _, tc := range tests {
+		query, err := url.ParseQuery(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseTimelineFilter(query)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseTimelineFilter(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseTimelineFilter(%q): got %+v, want %+v", tc.in, got, tc.want)
+		}
+		if tc.wantErr {
+			continue
+		}
+		if got, want := got.Query(), query; !reflect.DeepEqual(got, want) {
+			t.Errorf("parseTimelineFilter(%q).Query(): got %v, want %v", tc.in, got, want)
+		}
+	}
+}
+
+func TestFilterTimeline(t *testing.T) {
+	gopher := users.User{UserSpec: users.UserSpec{ID: 1}, Login: "gopher"}
+	bot := users.User{UserSpec: users.UserSpec{ID: 2}, Login: "gopherbot[bot]"}
+	timeline := []timelineItem{
+		{change.Comment{ID: "c1", User: gopher}},
+		{change.Review{ID: "r1", User: gopher, State: statepkg.ReviewPlus2}},
+		{change.Review{ID: "r2", User: bot}}, // A review without a vote is a comment.
+		{change.TimelineItem{ID: "e1", Actor: bot}},
+	}
+	tests := []struct {
+		filter timelineFilter
+		want   []string
+	}{
+		{filter: timelineFilter{}, want: []string{"c1", "r1", "r2", "e1"}},
+		{filter: timelineFilter{Show: "comments"}, want: []string{"c1", "r2"}},
+		{filter: timelineFilter{Show: "reviews"}, want: []string{"r1"}},
+		{filter: timelineFilter{Show: "events"}, want: []string{"e1"}},
+		{filter: timelineFilter{Author: "gopher"}, want: []string{"c1", "r1"}},
+		{filter: timelineFilter{HideBots: true}, want: []string{"c1", "r1"}},
+		{filter: timelineFilter{Show: "comments", HideBots: true}, want: []string{"c1"}},
+		{filter: timelineFilter{Author: "nobody"}, want: nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, item := range filterTimeline(timeline, tc.filter, defaultIsBot) {
+			got = append(got, item.ID())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("filterTimeline(%+v): got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultIsBot(t *testing.T) {
+	tests := []struct {
+		login string
+		want  bool
+	}{
+		{login: "dependabot[bot]", want: true},
+		{login: "gopherbot", want: false},
+		{login: "abbott", want: false},
+		{login: "robot-overlord", want: false},
+		{login: "[bot]er", want: false},
+	}
+	for _, tc := range tests {
+		if got := defaultIsBot(users.User{Login: tc.login}); got != tc.want {
+			t.Errorf("defaultIsBot(%q): got %v, want %v", tc.login, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"dmitri.shuralyov.com/service/change"
+	"honnef.co/go/js/dom"
+)
+
+// EditComment performs an action on the comment (or review) that contains el.
+// action is one of "edit", "cancel", "update".
+//
+// The comment is expected to be inside an element with id "comment-{{.ID}}",
+// and with a "data-body" attribute containing its raw Markdown body.
+func (f *frontend) EditComment(action string, el dom.HTMLElement) {
+	commentDiv := getAncestorByIDPrefix(el, "comment-")
+	if commentDiv == nil {
+		// Should never happen if EditComment is used correctly.
+		panic("EditComment: comment element not found")
+	}
+	container := commentDiv.QuerySelector(".list-entry-container").(dom.HTMLElement)
+
+	switch action {
+	case "edit":
+		if container.QuerySelector(".edit-comment") != nil {
+			// Already editing.
+			return
+		}
+		if body := container.QuerySelector(".list-entry-body"); body != nil {
+			body.(dom.HTMLElement).Style().SetProperty("display", "none", "")
+		}
+		editor := newCommentEditor(commentDiv.GetAttribute("data-body"))
+		editor.cancel.AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			f.EditComment("cancel", el)
+		})
+		editor.update.AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			f.EditComment("update", el)
+		})
+		container.AppendChild(editor.div)
+		editor.textArea.Focus()
+	case "cancel":
+		closeCommentEditor(container)
+	case "update":
+		editor := container.QuerySelector(".edit-comment").(dom.HTMLElement)
+		textArea := editor.QuerySelector("textarea").(*dom.HTMLTextAreaElement)
+		update := editor.QuerySelector("button.update").(*dom.HTMLButtonElement)
+		errorDiv := editor.QuerySelector(".edit-comment-error").(dom.HTMLElement)
+		update.Disabled = true
+		go func() {
+			defer func() { update.Disabled = false }()
+			body := textArea.Value
+			comment, err := f.cs.EditComment(context.Background(), state.RepoSpec, state.ChangeID, change.CommentRequest{
+				ID:   strings.TrimPrefix(commentDiv.ID(), "comment-"),
+				Body: &body,
+			})
+			if err != nil {
+				errorDiv.SetTextContent("Couldn't update the comment: " + err.Error())
+				errorDiv.Style().SetProperty("display", "block", "")
+				return
+			}
+			commentDiv.SetAttribute("data-body", comment.Body)
+			closeCommentEditor(container)
+			setCommentBody(container, comment.Body)
+		}()
+	}
+}
+
+// commentEditor is an in-place editor for the body of a comment.
+type commentEditor struct {
+	div            dom.HTMLElement
+	textArea       *dom.HTMLTextAreaElement
+	cancel, update dom.HTMLElement
+}
+
+// newCommentEditor creates a comment editor with Write and Preview tabs,
+// initialized with body.
+//
+// 	<div class="list-entry-body edit-comment">
+// 		<nav class="edit-comment-tabs"><a class="selected">Write</a><a>Preview</a></nav>
+// 		<textarea>{{.}}</textarea>
+// 		<div class="markdown-body" style="display: none;"></div>
+// 		<div class="edit-comment-error" style="display: none;"></div>
+// 		<div class="edit-comment-buttons"><button class="cancel">Cancel</button><button class="update">Update comment</button></div>
+// 	</div>
+func newCommentEditor(body string) commentEditor {
+	div := document.CreateElement("div").(dom.HTMLElement)
+	div.Class().SetString("list-entry-body edit-comment")
+
+	tabs := document.CreateElement("nav").(dom.HTMLElement)
+	tabs.Class().SetString("edit-comment-tabs")
+	write := document.CreateElement("a").(dom.HTMLElement)
+	write.SetTextContent("Write")
+	write.Class().Add("selected")
+	preview := document.CreateElement("a").(dom.HTMLElement)
+	preview.SetTextContent("Preview")
+	tabs.AppendChild(write)
+	tabs.AppendChild(preview)
+	div.AppendChild(tabs)
+
+	textArea := document.CreateElement("textarea").(*dom.HTMLTextAreaElement)
+	textArea.Value = body
+	div.AppendChild(textArea)
+
+	previewDiv := document.CreateElement("div").(dom.HTMLElement)
+	previewDiv.Class().SetString("markdown-body")
+	previewDiv.Style().SetProperty("display", "none", "")
+	div.AppendChild(previewDiv)
+
+	setupWritePreviewTabs(write, preview, textArea, previewDiv)
+
+	errorDiv := document.CreateElement("div").(dom.HTMLElement)
+	errorDiv.Class().SetString("edit-comment-error")
+	errorDiv.Style().SetProperty("display", "none", "")
+	div.AppendChild(errorDiv)
+
+	buttons := document.CreateElement("div").(dom.HTMLElement)
+	buttons.Class().SetString("edit-comment-buttons")
+	cancel := document.CreateElement("button").(dom.HTMLElement)
+	cancel.Class().SetString("cancel")
+	cancel.SetTextContent("Cancel")
+	update := document.CreateElement("button").(dom.HTMLElement)
+	update.Class().SetString("update")
+	update.SetTextContent("Update comment")
+	buttons.AppendChild(cancel)
+	buttons.AppendChild(update)
+	div.AppendChild(buttons)
+
+	return commentEditor{div: div, textArea: textArea, cancel: cancel, update: update}
+}
+
+// setupWritePreviewTabs makes the write and preview tabs switch between
+// editing Markdown in textArea, and previewing it rendered in previewDiv.
+func setupWritePreviewTabs(write, preview dom.HTMLElement, textArea *dom.HTMLTextAreaElement, previewDiv dom.HTMLElement) {
+	write.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		write.Class().Add("selected")
+		preview.Class().Remove("selected")
+		previewDiv.Style().SetProperty("display", "none", "")
+		textArea.Style().SetProperty("display", "", "")
+		textArea.Focus()
+	})
+	preview.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		preview.Class().Add("selected")
+		write.Class().Remove("selected")
+		previewDiv.SetTextContent("Loading preview...")
+		textArea.Style().SetProperty("display", "none", "")
+		previewDiv.Style().SetProperty("display", "", "")
+		go func() {
+			html, err := markdown(textArea.Value)
+			if err != nil {
+				previewDiv.SetTextContent("Couldn't load preview: " + err.Error())
+				return
+			}
+			previewDiv.SetInnerHTML(html)
+		}()
+	})
+}
+
+// setupNewComment enables the Markdown preview of the new comment form, if it's present.
+// The form works without it, so its Write and Preview tabs are hidden until then.
+func setupNewComment() {
+	form, ok := document.GetElementByID("new-comment").(dom.HTMLElement)
+	if !ok {
+		return
+	}
+	tabs := form.QuerySelector(".edit-comment-tabs").(dom.HTMLElement)
+	setupWritePreviewTabs(
+		tabs.QuerySelector(".write").(dom.HTMLElement),
+		tabs.QuerySelector(".preview").(dom.HTMLElement),
+		form.QuerySelector("textarea").(*dom.HTMLTextAreaElement),
+		form.QuerySelector(".markdown-body").(dom.HTMLElement),
+	)
+	tabs.Style().SetProperty("display", "", "")
+}
+
+// closeCommentEditor removes the comment editor from container,
+// and shows the comment body again.
+func closeCommentEditor(container dom.HTMLElement) {
+	if editor := container.QuerySelector(".edit-comment"); editor != nil {
+		container.RemoveChild(editor)
+	}
+	if body := container.QuerySelector(".list-entry-body"); body != nil {
+		body.(dom.HTMLElement).Style().SetProperty("display", "", "")
+	}
+}
+
+// setCommentBody re-renders the comment body in container to body.
+// It makes a network request, so it must not be called on the main goroutine.
+func setCommentBody(container dom.HTMLElement, body string) {
+	markdownBody := container.QuerySelector(".list-entry-body .markdown-body")
+	if markdownBody == nil {
+		// A review without a body, so there's no element for it yet.
+		div := document.CreateElement("div").(dom.HTMLElement)
+		div.Class().SetString("list-entry-body")
+		markdownBody = document.CreateElement("div")
+		markdownBody.Class().SetString("markdown-body")
+		div.AppendChild(markdownBody)
+		container.AppendChild(div)
+	}
+	if body == "" {
+		markdownBody.SetInnerHTML(`<i class="gray">No description.</i>`)
+		return
+	}
+	html, err := markdown(body)
+	if err != nil {
+		// The comment was updated, but it can't be rendered. Display it as plain text instead.
+		markdownBody.SetTextContent(body)
+		return
+	}
+	markdownBody.SetInnerHTML(html)
+}
+
+// markdown renders the GitHub Flavored Markdown text as HTML,
+// using the preview endpoint, so that it's rendered the same way as on pages.
+// On a change page, references to the change's commits and participants are linked.
+func markdown(text string) (string, error) {
+	url := state.BaseURI + "/preview"
+	if state.ChangeID != 0 {
+		url += fmt.Sprintf("?change=%d", state.ChangeID)
+	}
+	resp, err := http.Post(url, "text/markdown; charset=utf-8", strings.NewReader(text))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	html, err := ioutil.ReadAll(resp.Body)
+	return string(html), err
+}
+
+// getAncestorByIDPrefix returns the closest ancestor of el (including el itself)
+// whose id has the given prefix, or nil if there isn't one.
+func getAncestorByIDPrefix(el dom.Element, prefix string) dom.Element {
+	for ; el != nil && !strings.HasPrefix(el.ID(), prefix); el = el.ParentElement() {
+	}
+	return el
+}
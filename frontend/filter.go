@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/gopherjs/gopherjs/js"
+	"honnef.co/go/js/dom"
+)
+
+// setupTimelineFilter makes the timeline filter controls apply instantly,
+// by hiding timeline items on the page rather than loading a filtered page.
+// It's only possible when the entire timeline is on the page.
+func setupTimelineFilter() {
+	timeline, ok := document.GetElementByID("timeline").(dom.HTMLElement)
+	if !ok || !timeline.HasAttribute("data-complete") {
+		return
+	}
+	for _, el := range document.QuerySelectorAll(".timeline-filter-group a") {
+		link := el.(dom.HTMLElement)
+		link.AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			group := link.ParentElement()
+			if group.GetAttribute("data-key") == "hide" {
+				// A toggle.
+				if link.Class().Contains("selected") {
+					link.Class().Remove("selected")
+				} else {
+					link.Class().Add("selected")
+				}
+			} else {
+				for _, a := range group.QuerySelectorAll("a") {
+					a.Class().Remove("selected")
+				}
+				link.Class().Add("selected")
+			}
+			applyTimelineFilter()
+		})
+	}
+}
+
+// applyTimelineFilter shows only timeline items that match the filter
+// selected in the timeline filter controls, and updates the page URL to match it.
+func applyTimelineFilter() {
+	selected := func(key string) string {
+		a := document.QuerySelector(`.timeline-filter-group[data-key="` + key + `"] a.selected`)
+		if a == nil {
+			return ""
+		}
+		if key == "hide" {
+			return "bots"
+		}
+		return a.GetAttribute("data-value")
+	}
+	show, author, hide := selected("show"), selected("author"), selected("hide")
+
+	for _, el := range document.QuerySelectorAll("#timeline > .timeline-item") {
+		item := el.(dom.HTMLElement)
+		visible := (show == "" || item.GetAttribute("data-kind") == show) &&
+			(author == "" || item.GetAttribute("data-author") == author) &&
+			(hide == "" || !item.HasAttribute("data-bot"))
+		if visible {
+			item.Style().SetProperty("display", "", "")
+		} else {
+			item.Style().SetProperty("display", "none", "")
+		}
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"show": show, "author": author, "hide": hide} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	windowLocation.RawQuery = query.Encode()
+	js.Global.Get("window").Get("history").Call("replaceState", nil, nil, windowLocation.String())
+}
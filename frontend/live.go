@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gopherjs/gopherjs/js"
+	"honnef.co/go/js/dom"
+)
+
+// setupLiveUpdates subscribes to new timeline items of the change, if its timeline is displayed,
+// and appends them to the timeline as they arrive.
+func setupLiveUpdates() {
+	timeline, ok := document.GetElementByID("timeline").(dom.HTMLElement)
+	if !ok || js.Global.Get("EventSource") == js.Undefined {
+		return
+	}
+	events := js.Global.Get("EventSource").New(timeline.GetAttribute("data-events-url"))
+	events.Call("addEventListener", "timeline", func(e *js.Object) {
+		var data struct {
+			Items  string `json:"items"`
+			Tabnav string `json:"tabnav"`
+		}
+		err := json.Unmarshal([]byte(e.Get("data").String()), &data)
+		if err != nil {
+			// Should never happen, since the data is made by the server.
+			panic(fmt.Errorf("setupLiveUpdates: json.Unmarshal: %v", err))
+		}
+		appendTimelineItems(timeline, data.Items)
+		if timeline.HasAttribute("data-complete") {
+			// New items need to be filtered too.
+			applyTimelineFilter()
+		}
+		if tabnav := document.QuerySelector(".tabnav"); tabnav != nil && data.Tabnav != "" {
+			div := document.CreateElement("div")
+			div.SetInnerHTML(data.Tabnav)
+			tabnav.ParentNode().ReplaceChild(div.QuerySelector(".tabnav"), tabnav)
+		}
+	})
+}
+
+// appendTimelineItems appends the timeline items rendered as itemsHTML to timeline,
+// makes them flash, and shows a "new activity" indicator that scrolls to them.
+func appendTimelineItems(timeline dom.HTMLElement, itemsHTML string) {
+	div := document.CreateElement("div")
+	div.SetInnerHTML(itemsHTML)
+	var first dom.HTMLElement
+	for _, n := range div.ChildNodes() {
+		item, ok := n.(dom.HTMLElement)
+		if !ok {
+			// Whitespace between items.
+			continue
+		}
+		item.Class().Add("new-activity")
+		timeline.AppendChild(item)
+		if first == nil {
+			first = item
+		}
+	}
+	if first == nil {
+		return
+	}
+	go func() {
+		time.Sleep(3 * time.Second)
+		for _, el := range timeline.QuerySelectorAll(".new-activity") {
+			el.Class().Remove("new-activity")
+		}
+	}()
+	showNewActivity(first)
+}
+
+// showNewActivity shows a "new activity" indicator, which scrolls to target when clicked.
+// There's at most one indicator, pointing to the oldest new item.
+func showNewActivity(target dom.HTMLElement) {
+	if document.GetElementByID("new-activity-indicator") != nil {
+		return
+	}
+	indicator := document.CreateElement("a").(dom.HTMLElement)
+	indicator.SetID("new-activity-indicator")
+	indicator.SetAttribute("href", "javascript:")
+	indicator.SetTextContent("New activity")
+	indicator.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		centerWindowOn(target)
+		indicator.ParentNode().RemoveChild(indicator)
+	})
+	document.Body().AppendChild(indicator)
+}
@@ -36,6 +36,7 @@ func main() {
 	f := &frontend{cs: httpclient.NewChange(httpClient, "", "")}
 
 	js.Global.Set("ToggleDetails", jsutil.Wrap(ToggleDetails))
+	js.Global.Set("EditComment", jsutil.Wrap(f.EditComment))
 
 	switch readyState := document.ReadyState(); readyState {
 	case "loading":
@@ -50,12 +51,19 @@ func main() {
 }
 
 func setup(f *frontend) {
-	setupScroll()
-
+	// Reactions are set up first, since timeline items that are inserted later,
+	// by setupTimeline and setupLiveUpdates, rely on the handlers it registers.
 	if !state.DisableReactions {
 		reactionsService := ChangeReactions{Change: f.cs}
 		reactionsmenu.Setup(state.RepoSpec, reactionsService, state.CurrentUser)
 	}
+
+	setupScroll()
+	setupNewComment()
+	setupReview()
+	setupTimeline()
+	setupTimelineFilter()
+	setupLiveUpdates()
 }
 
 // httpClient gives an *http.Client for making API requests.
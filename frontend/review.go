@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"honnef.co/go/js/dom"
+)
+
+// pendingComment is an inline comment that will be submitted with the review.
+type pendingComment struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Body string `json:"body"`
+}
+
+// review keeps track of pending inline comments of a review that's being written.
+type review struct {
+	form     dom.HTMLElement
+	comments []*pendingComment
+}
+
+// setupReview enables adding pending inline comments on lines of file diffs,
+// if the review form is present. The comments are submitted together with the review form.
+func setupReview() {
+	form, ok := document.GetElementByID("review-form").(dom.HTMLElement)
+	if !ok {
+		return
+	}
+	r := &review{form: form}
+	for _, row := range document.QuerySelectorAll("table.highlight-diff tr[data-line]") {
+		row := row.(dom.HTMLElement)
+		button := document.CreateElement("button").(dom.HTMLElement)
+		button.Class().SetString("add-comment")
+		button.SetAttribute("title", "Add a review comment on this line")
+		button.SetTextContent("+")
+		button.AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			r.openCommentForm(row)
+		})
+		line := row.QuerySelector("td.line:last-child") // The new file is on the right side of a split diff.
+		line.InsertBefore(button, line.FirstChild())
+	}
+}
+
+// openCommentForm inserts a form for writing an inline comment below row.
+//
+// 	<tr class="inline-comment-form"><td colspan="3">
+// 		<textarea></textarea>
+// 		<div class="edit-comment-buttons"><button>Cancel</button><button>Add review comment</button></div>
+// 	</td></tr>
+func (r *review) openCommentForm(row dom.HTMLElement) {
+	if next, ok := row.NextElementSibling().(dom.HTMLElement); ok && next.Class().Contains("inline-comment-form") {
+		// Already open.
+		next.QuerySelector("textarea").(*dom.HTMLTextAreaElement).Focus()
+		return
+	}
+	file := getAncestorByClassName(row, "file-diff").GetAttribute("data-file")
+	line, err := strconv.Atoi(row.GetAttribute("data-line"))
+	if err != nil {
+		// Should never happen, since data-line is rendered by the server.
+		panic(fmt.Errorf("openCommentForm: invalid data-line: %v", err))
+	}
+
+	columns := len(row.QuerySelectorAll("td"))
+	tr := newInlineCommentRow("inline-comment-form", columns)
+	td := tr.QuerySelector("td")
+	textArea := document.CreateElement("textarea").(*dom.HTMLTextAreaElement)
+	td.AppendChild(textArea)
+	buttons := document.CreateElement("div").(dom.HTMLElement)
+	buttons.Class().SetString("edit-comment-buttons")
+	cancel := document.CreateElement("button").(dom.HTMLElement)
+	cancel.SetTextContent("Cancel")
+	cancel.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		tr.ParentNode().RemoveChild(tr)
+	})
+	add := document.CreateElement("button").(dom.HTMLElement)
+	add.SetTextContent("Add review comment")
+	add.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		if textArea.Value == "" {
+			return
+		}
+		c := &pendingComment{File: file, Line: line, Body: textArea.Value}
+		r.comments = append(r.comments, c)
+		r.update()
+		tr.ParentNode().ReplaceChild(r.newPendingCommentRow(c, columns), tr)
+	})
+	buttons.AppendChild(cancel)
+	buttons.AppendChild(add)
+	td.AppendChild(buttons)
+
+	row.ParentNode().InsertBefore(tr, row.NextSibling())
+	textArea.Focus()
+}
+
+// newPendingCommentRow returns a row that displays pending comment c,
+// with a button to remove it. The row spans the given number of columns.
+//
+// 	<tr class="inline-comment pending"><td colspan="3">
+// 		<div class="pending-label">Pending <a>Remove</a></div>
+// 		<div class="markdown-body">{{.Body}}</div>
+// 	</td></tr>
+func (r *review) newPendingCommentRow(c *pendingComment, columns int) dom.HTMLElement {
+	tr := newInlineCommentRow("inline-comment pending", columns)
+	td := tr.QuerySelector("td")
+	label := document.CreateElement("div").(dom.HTMLElement)
+	label.Class().SetString("pending-label gray")
+	label.SetTextContent("Pending ")
+	remove := document.CreateElement("a").(dom.HTMLElement)
+	remove.SetAttribute("href", "javascript:")
+	remove.SetTextContent("Remove")
+	remove.AddEventListener("click", false, func(e dom.Event) {
+		e.PreventDefault()
+		r.remove(c)
+		tr.ParentNode().RemoveChild(tr)
+	})
+	label.AppendChild(remove)
+	td.AppendChild(label)
+	body := document.CreateElement("div").(dom.HTMLElement)
+	body.Class().SetString("markdown-body")
+	body.SetTextContent(c.Body)
+	td.AppendChild(body)
+	go func() {
+		html, err := markdown(c.Body)
+		if err != nil {
+			// Keep displaying it as plain text.
+			return
+		}
+		body.SetInnerHTML(html)
+	}()
+	return tr
+}
+
+// newInlineCommentRow returns an empty diff table row with the given class,
+// and a single cell spanning all columns. Unified diffs have 3 columns,
+// and split diffs have 4.
+func newInlineCommentRow(class string, columns int) dom.HTMLElement {
+	tr := document.CreateElement("tr").(dom.HTMLElement)
+	tr.Class().SetString(class)
+	td := document.CreateElement("td").(dom.HTMLElement)
+	td.SetAttribute("colspan", strconv.Itoa(columns))
+	tr.AppendChild(td)
+	return tr
+}
+
+// remove removes pending comment c.
+func (r *review) remove(c *pendingComment) {
+	for i := range r.comments {
+		if r.comments[i] == c {
+			r.comments = append(r.comments[:i], r.comments[i+1:]...)
+			break
+		}
+	}
+	r.update()
+}
+
+// update updates the review form with the current pending comments.
+func (r *review) update() {
+	comments, err := json.Marshal(r.comments)
+	if err != nil {
+		// Should never happen.
+		panic(fmt.Errorf("update: json.Marshal: %v", err))
+	}
+	r.form.QuerySelector(`input[name="comments"]`).(*dom.HTMLInputElement).Value = string(comments)
+
+	var count string
+	switch len(r.comments) {
+	case 0:
+	case 1:
+		count = "(1 pending comment)"
+	default:
+		count = fmt.Sprintf("(%d pending comments)", len(r.comments))
+	}
+	getAncestorByClassName(r.form, "review-panel").QuerySelector(".pending-count").SetTextContent(count)
+}
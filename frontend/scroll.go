@@ -67,9 +67,18 @@ func setupScroll() {
 func processHash() {
 	// Scroll to hash target.
 	targetID := strings.TrimPrefix(dom.GetWindow().Location().Hash, "#")
+	// The hash may be escaped, e.g., links to lines of files in directories
+	// have "/" escaped as "%2f", but IDs of elements aren't.
+	if id, err := url.PathUnescape(targetID); err == nil {
+		targetID = id
+	}
 	target, ok := document.GetElementByID(targetID).(dom.HTMLElement)
 	if ok {
 		centerWindowOn(target)
+	} else if targetID != "" && expandTimelineFor(targetID) {
+		// The target is in a collapsed part of the timeline.
+		// It'll be processed again once that part is loaded.
+		return
 	}
 
 	highlight(target)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"honnef.co/go/js/dom"
+)
+
+// setupTimeline makes "load more" controls of a collapsed timeline
+// load the hidden items in place, instead of reloading the entire page.
+func setupTimeline() {
+	for _, el := range document.QuerySelectorAll(".timeline-hidden") {
+		hidden := el.(dom.HTMLElement)
+		hidden.QuerySelector("a").AddEventListener("click", false, func(e dom.Event) {
+			e.PreventDefault()
+			go func() {
+				err := expandTimeline(hidden)
+				if err != nil {
+					hidden.Class().Remove("loading")
+					hidden.SetTextContent("Couldn't load hidden items: " + err.Error())
+				}
+			}()
+		})
+	}
+}
+
+// expandTimelineFor expands the collapsed part of the timeline that contains
+// the element with the given id, if any, and then processes the hash again.
+// It reports whether there is such a collapsed part.
+func expandTimelineFor(id string) bool {
+	for _, el := range document.QuerySelectorAll(".timeline-hidden") {
+		hidden := el.(dom.HTMLElement)
+		for _, anchor := range strings.Fields(hidden.GetAttribute("data-anchors")) {
+			if anchor != id {
+				continue
+			}
+			go func() {
+				err := expandTimeline(hidden)
+				if err != nil {
+					hidden.Class().Remove("loading")
+					hidden.SetTextContent("Couldn't load hidden items: " + err.Error())
+					return
+				}
+				processHash()
+			}()
+			return true
+		}
+	}
+	return false
+}
+
+// expandTimeline replaces the collapsed part of the timeline hidden
+// with the timeline items it contains. It makes a network request,
+// so it must not be called on the main goroutine.
+func expandTimeline(hidden dom.HTMLElement) error {
+	if hidden.Class().Contains("loading") {
+		return nil
+	}
+	hidden.Class().Add("loading")
+	hidden.QuerySelector("a").SetTextContent("Loading...")
+	resp, err := http.Get(hidden.GetAttribute("data-url"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	div := document.CreateElement("div")
+	div.SetInnerHTML(string(body))
+	parent := hidden.ParentNode()
+	for _, n := range div.ChildNodes() {
+		parent.InsertBefore(n, hidden)
+	}
+	parent.RemoveChild(hidden)
+	return nil
+}
@@ -0,0 +1,468 @@
+package changes
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/issues"
+	"github.com/shurcooL/reactions"
+	"github.com/shurcooL/users"
+	"github.com/sourcegraph/go-diff/diff"
+)
+
+// jsonSchemaVersion is the version of the JSON documents served by page handlers.
+// It must be incremented whenever a change to them isn't backwards compatible.
+const jsonSchemaVersion = 1
+
+// formatQueryKey is name of query key for requesting a format other than HTML.
+// The only supported value is "json".
+const formatQueryKey = "format"
+
+// wantsJSON reports whether req asks for a JSON document instead of an HTML page,
+// either via the format query parameter, or via the Accept header.
+func wantsJSON(req *http.Request) bool {
+	if format := req.URL.Query().Get(formatQueryKey); format != "" {
+		return format == "json"
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+// writeJSON writes v as an indented JSON document to w.
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(v)
+	if err != nil {
+		return fmt.Errorf("json.Encode: %v", err)
+	}
+	return nil
+}
+
+// changesDocument is the JSON document served by ChangesHandler.
+type changesDocument struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	State         string      `json:"state"` // Name of selected state tab. Empty for the default tab.
+	Search        string      `json:"search"`
+	Sort          string      `json:"sort"` // Empty for the default sort order.
+	Page          int         `json:"page"`
+	PageCount     int         `json:"pageCount"` // 0 if unknown.
+	Counts        countsJSON  `json:"counts"`
+	Changes       []entryJSON `json:"changes"`
+	Failures      []string    `json:"failures,omitempty"`
+}
+
+// countsJSON holds counts of changes in each state.
+// Counts that couldn't be fetched are null, and listed in failures.
+type countsJSON struct {
+	Open   *uint64 `json:"open"`
+	Closed *uint64 `json:"closed"` // Including merged changes.
+	Merged *uint64 `json:"merged"` // Also null, without a failure, if the change service can't count merged changes.
+	All    *uint64 `json:"all"`
+	Unread *uint64 `json:"unread,omitempty"` // Only set if notifications are enabled.
+}
+
+type entryJSON struct {
+	changeJSON
+	Unread bool `json:"unread"`
+}
+
+// changeDocument is the JSON document served by ChangeHandler.
+type changeDocument struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Change        changeJSON     `json:"change"`
+	Timeline      []timelineJSON `json:"timeline"` // Sorted by creation time.
+	Failures      []string       `json:"failures,omitempty"`
+}
+
+// commitsDocument is the JSON document served by ChangeCommitsHandler.
+type commitsDocument struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Change        changeJSON   `json:"change"`
+	Commits       []commitJSON `json:"commits"`
+	Failures      []string     `json:"failures,omitempty"`
+}
+
+// filesDocument is the JSON document served by ChangeFilesHandler.
+type filesDocument struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Change        changeJSON     `json:"change"`
+	Commit        *commitJSON    `json:"commit,omitempty"` // Only set for single-commit view.
+	Files         []fileDiffJSON `json:"files"`
+	Failures      []string       `json:"failures,omitempty"`
+}
+
+func newChangesDocument(s state, sortBy string) changesDocument {
+	doc := changesDocument{
+		SchemaVersion: jsonSchemaVersion,
+		State:         s.Changes.StateTab.Name,
+		Search:        s.Changes.Search,
+		Sort:          sortBy,
+		Page:          s.Changes.Pagination.Page,
+		PageCount:     s.Changes.Pagination.PageCount,
+		Counts: countsJSON{
+			Open:   countJSON(s.Changes.ChangesNav.Counts, "open"),
+			Closed: countJSON(s.Changes.ChangesNav.Counts, "closed"),
+			Merged: countJSON(s.Changes.ChangesNav.Counts, "merged"),
+			All:    countJSON(s.Changes.ChangesNav.Counts, ""),
+			Unread: countJSON(s.Changes.ChangesNav.Counts, "unread"),
+		},
+		Changes:  []entryJSON{},
+		Failures: s.failures,
+	}
+	for _, e := range s.Changes.Entries {
+		doc.Changes = append(doc.Changes, entryJSON{
+			changeJSON: newChangeJSON(e.Change),
+			Unread:     e.Unread,
+		})
+	}
+	return doc
+}
+
+func countJSON(counts map[string]uint64, is string) *uint64 {
+	count, ok := counts[is]
+	if !ok {
+		return nil
+	}
+	return &count
+}
+
+func newChangeDocument(s state, rs component.EventRenderers) changeDocument {
+	doc := changeDocument{
+		SchemaVersion: jsonSchemaVersion,
+		Change:        newChangeJSON(s.Change),
+		Timeline:      []timelineJSON{},
+		Failures:      s.failures,
+	}
+	for _, item := range s.Timeline {
+		doc.Timeline = append(doc.Timeline, newTimelineJSON(item, rs))
+	}
+	return doc
+}
+
+func newCommitsDocument(s state, cs []change.Commit) commitsDocument {
+	doc := commitsDocument{
+		SchemaVersion: jsonSchemaVersion,
+		Change:        newChangeJSON(s.Change),
+		Commits:       []commitJSON{},
+		Failures:      s.failures,
+	}
+	for _, c := range cs {
+		doc.Commits = append(doc.Commits, newCommitJSON(c))
+	}
+	return doc
+}
+
+func newFilesDocument(s state, commit *change.Commit, fileDiffs []*diff.FileDiff) filesDocument {
+	doc := filesDocument{
+		SchemaVersion: jsonSchemaVersion,
+		Change:        newChangeJSON(s.Change),
+		Files:         []fileDiffJSON{},
+		Failures:      s.failures,
+	}
+	if commit != nil {
+		c := newCommitJSON(*commit)
+		doc.Commit = &c
+	}
+	for _, f := range fileDiffs {
+		doc.Files = append(doc.Files, newFileDiffJSON(f))
+	}
+	return doc
+}
+
+type changeJSON struct {
+	ID           uint64      `json:"id"`
+	State        string      `json:"state"`
+	Title        string      `json:"title"`
+	Labels       []labelJSON `json:"labels"`
+	Author       userJSON    `json:"author"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	Replies      int         `json:"replies"`
+	Commits      int         `json:"commits"`
+	ChangedFiles int         `json:"changedFiles"`
+}
+
+func newChangeJSON(c change.Change) changeJSON {
+	labels := []labelJSON{}
+	for _, l := range c.Labels {
+		labels = append(labels, newLabelJSON(l))
+	}
+	return changeJSON{
+		ID:           c.ID,
+		State:        string(c.State),
+		Title:        c.Title,
+		Labels:       labels,
+		Author:       newUserJSON(c.Author),
+		CreatedAt:    c.CreatedAt,
+		Replies:      c.Replies,
+		Commits:      c.Commits,
+		ChangedFiles: c.ChangedFiles,
+	}
+}
+
+type labelJSON struct {
+	Name  string `json:"name"`
+	Color string `json:"color"` // Hex color, e.g., "#ededed".
+}
+
+func newLabelJSON(l issues.Label) labelJSON {
+	return labelJSON{Name: l.Name, Color: l.Color.HexString()}
+}
+
+type userJSON struct {
+	Login     string `json:"login"`
+	Name      string `json:"name,omitempty"`
+	AvatarURL string `json:"avatarURL,omitempty"`
+	HTMLURL   string `json:"htmlURL,omitempty"`
+}
+
+func newUserJSON(u users.User) userJSON {
+	return userJSON{Login: u.Login, Name: u.Name, AvatarURL: u.AvatarURL, HTMLURL: u.HTMLURL}
+}
+
+func newUsersJSON(us []users.User) []userJSON {
+	var js []userJSON
+	for _, u := range us {
+		js = append(js, newUserJSON(u))
+	}
+	return js
+}
+
+// timelineJSON is a timeline item. Type is one of "comment", "review", "event",
+// and determines which of the other fields are set.
+type timelineJSON struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Actor     userJSON  `json:"actor"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Set for comments and reviews.
+	Edited    *editedJSON    `json:"edited,omitempty"`
+	Body      string         `json:"body,omitempty"`
+	Reactions []reactionJSON `json:"reactions,omitempty"`
+
+	// Set for reviews.
+	Review   *int                `json:"review,omitempty"` // Review score, e.g., +2, 0, -1.
+	Comments []inlineCommentJSON `json:"comments,omitempty"`
+
+	// Set for events.
+	Event *eventJSON `json:"event,omitempty"`
+}
+
+type editedJSON struct {
+	By userJSON  `json:"by"`
+	At time.Time `json:"at"`
+}
+
+func newEditedJSON(e *change.Edited) *editedJSON {
+	if e == nil {
+		return nil
+	}
+	return &editedJSON{By: newUserJSON(e.By), At: e.At}
+}
+
+type reactionJSON struct {
+	Reaction string     `json:"reaction"`
+	Users    []userJSON `json:"users"`
+}
+
+func newReactionsJSON(rs []reactions.Reaction) []reactionJSON {
+	var js []reactionJSON
+	for _, r := range rs {
+		js = append(js, reactionJSON{Reaction: string(r.Reaction), Users: newUsersJSON(r.Users)})
+	}
+	return js
+}
+
+type inlineCommentJSON struct {
+	ID        string         `json:"id"`
+	File      string         `json:"file"`
+	Line      int            `json:"line"`
+	Body      string         `json:"body"`
+	Reactions []reactionJSON `json:"reactions,omitempty"`
+}
+
+// eventJSON is the payload of an event. Type determines which of the other fields are set.
+type eventJSON struct {
+	Type     string     `json:"type"`          // One of "closed", "reopened", "renamed", "labeled", "unlabeled", "review-requested", "review-request-removed", "merged", "deleted", "custom", "unknown".
+	URL      string     `json:"url,omitempty"` // URL of the closer of a closed event, or the commit of a merged event.
+	From     string     `json:"from,omitempty"`
+	To       string     `json:"to,omitempty"`
+	Label    *labelJSON `json:"label,omitempty"`
+	Reviewer *userJSON  `json:"reviewer,omitempty"`
+	CommitID string     `json:"commitID,omitempty"`
+	RefName  string     `json:"refName,omitempty"`
+	Kind     string     `json:"kind,omitempty"` // Kind of the deleted object, e.g., "branch".
+	Name     string     `json:"name,omitempty"` // Name of the deleted object.
+	Text     string     `json:"text,omitempty"` // Description of a custom event.
+}
+
+func newTimelineJSON(item timelineItem, rs component.EventRenderers) timelineJSON {
+	switch i := item.TimelineItem.(type) {
+	case change.Comment:
+		return timelineJSON{
+			Type:      "comment",
+			ID:        i.ID,
+			Actor:     newUserJSON(i.User),
+			CreatedAt: i.CreatedAt,
+			Edited:    newEditedJSON(i.Edited),
+			Body:      i.Body,
+			Reactions: newReactionsJSON(i.Reactions),
+		}
+	case change.Review:
+		review := int(i.State)
+		var comments []inlineCommentJSON
+		for _, c := range i.Comments {
+			comments = append(comments, inlineCommentJSON{
+				ID:        c.ID,
+				File:      c.File,
+				Line:      c.Line,
+				Body:      c.Body,
+				Reactions: newReactionsJSON(c.Reactions),
+			})
+		}
+		return timelineJSON{
+			Type:      "review",
+			ID:        i.ID,
+			Actor:     newUserJSON(i.User),
+			CreatedAt: i.CreatedAt,
+			Edited:    newEditedJSON(i.Edited),
+			Body:      i.Body,
+			Reactions: newReactionsJSON(i.Reactions),
+			Review:    &review,
+			Comments:  comments,
+		}
+	case change.TimelineItem:
+		return timelineJSON{
+			Type:      "event",
+			ID:        i.ID,
+			Actor:     newUserJSON(i.Actor),
+			CreatedAt: i.CreatedAt,
+			Event:     newEventJSON(i.Payload, rs),
+		}
+	default:
+		panic(fmt.Errorf("unknown item type %T", i))
+	}
+}
+
+// newEventJSON returns the JSON representation of an event with the given payload.
+// Payloads of types not in the change package are "custom" events, described by
+// the text of their renderer registered in rs, if any.
+func newEventJSON(payload interface{}, rs component.EventRenderers) *eventJSON {
+	switch p := payload.(type) {
+	case change.ClosedEvent:
+		return &eventJSON{Type: "closed", URL: p.CloserHTMLURL}
+	case change.ReopenedEvent:
+		return &eventJSON{Type: "reopened"}
+	case change.RenamedEvent:
+		return &eventJSON{Type: "renamed", From: p.From, To: p.To}
+	case change.LabeledEvent:
+		l := newLabelJSON(p.Label)
+		return &eventJSON{Type: "labeled", Label: &l}
+	case change.UnlabeledEvent:
+		l := newLabelJSON(p.Label)
+		return &eventJSON{Type: "unlabeled", Label: &l}
+	case change.ReviewRequestedEvent:
+		u := newUserJSON(p.RequestedReviewer)
+		return &eventJSON{Type: "review-requested", Reviewer: &u}
+	case change.ReviewRequestRemovedEvent:
+		u := newUserJSON(p.RequestedReviewer)
+		return &eventJSON{Type: "review-request-removed", Reviewer: &u}
+	case change.MergedEvent:
+		return &eventJSON{Type: "merged", URL: p.CommitHTMLURL, CommitID: p.CommitID, RefName: p.RefName}
+	case change.DeletedEvent:
+		return &eventJSON{Type: "deleted", Kind: p.Type, Name: p.Name}
+	default:
+		if text, ok := rs.PlainText(p); ok {
+			return &eventJSON{Type: "custom", Text: text}
+		}
+		return &eventJSON{Type: "unknown"}
+	}
+}
+
+type commitJSON struct {
+	SHA        string    `json:"sha"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	Author     userJSON  `json:"author"`
+	AuthorTime time.Time `json:"authorTime"`
+}
+
+func newCommitJSON(c change.Commit) commitJSON {
+	subject, body := splitCommitMessage(c.Message)
+	return commitJSON{
+		SHA:        c.SHA,
+		Subject:    subject,
+		Body:       body,
+		Author:     newUserJSON(c.Author),
+		AuthorTime: c.AuthorTime,
+	}
+}
+
+// fileDiffJSON is a parsed file diff. Paths don't include the "a/" and "b/" prefixes.
+// OldPath is empty for added files, and NewPath is empty for removed files.
+type fileDiffJSON struct {
+	OldPath  string     `json:"oldPath"`
+	NewPath  string     `json:"newPath"`
+	Extended []string   `json:"extended,omitempty"` // Extended header lines, e.g., "new file mode 100644".
+	Added    int        `json:"added"`              // Number of added lines.
+	Deleted  int        `json:"deleted"`            // Number of deleted lines.
+	Hunks    []hunkJSON `json:"hunks"`
+}
+
+type hunkJSON struct {
+	OldStartLine int    `json:"oldStartLine"`
+	OldLines     int    `json:"oldLines"`
+	NewStartLine int    `json:"newStartLine"`
+	NewLines     int    `json:"newLines"`
+	Section      string `json:"section,omitempty"` // Optional section heading.
+	Body         string `json:"body"`              // Hunk lines, each prefixed with ' ', '+' or '-'.
+}
+
+func newFileDiffJSON(f *diff.FileDiff) fileDiffJSON {
+	path := func(name, prefix string) string {
+		if name == "/dev/null" {
+			return ""
+		}
+		return strings.TrimPrefix(name, prefix)
+	}
+	stat := f.Stat()
+	js := fileDiffJSON{
+		OldPath:  path(f.OrigName, "a/"),
+		NewPath:  path(f.NewName, "b/"),
+		Extended: f.Extended,
+		// A changed line is a deleted line followed by an added line.
+		Added:   int(stat.Added + stat.Changed),
+		Deleted: int(stat.Deleted + stat.Changed),
+		Hunks:   []hunkJSON{},
+	}
+	for _, h := range f.Hunks {
+		js.Hunks = append(js.Hunks, hunkJSON{
+			OldStartLine: int(h.OrigStartLine),
+			OldLines:     int(h.OrigLines),
+			NewStartLine: int(h.NewStartLine),
+			NewLines:     int(h.NewLines),
+			Section:      h.Section,
+			Body:         string(h.Body),
+		})
+	}
+	return js
+}
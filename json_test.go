@@ -0,0 +1,145 @@
+package changes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   bool
+	}{
+		{query: "", accept: "", want: false},
+		{query: "format=json", accept: "", want: true},
+		{query: "format=html", accept: "application/json", want: false}, // The format parameter takes precedence.
+		{query: "format=json", accept: "text/html", want: true},
+		{query: "", accept: "application/json", want: true},
+		{query: "", accept: "application/json; charset=utf-8", want: true},
+		{query: "", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: false},
+		{query: "", accept: "text/html, application/json", want: false}, // The first supported type wins.
+		{query: "", accept: "application/json, text/html", want: true},
+		{query: "", accept: "image/webp, application/json", want: true},
+		{query: "", accept: "*/*", want: false},
+		{query: "", accept: "not a media type;;, application/json", want: true},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/changes?"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		if got := wantsJSON(req); got != tc.want {
+			t.Errorf("wantsJSON(%q, Accept: %q): got %v, want %v", tc.query, tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestNewFileDiffJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		diff        string
+		wantOld     string
+		wantNew     string
+		wantAdded   int
+		wantDeleted int
+		wantHunks   int
+	}{
+		{
+			name:        "modified, with changed lines",
+			diff:        testDiff,
+			wantOld:     "f.go",
+			wantNew:     "f.go",
+			wantAdded:   2,
+			wantDeleted: 2,
+			wantHunks:   2,
+		},
+		{
+			name: "only changed lines",
+			diff: `--- a/f.go
++++ b/f.go
+@@ -1,2 +1,2 @@
+-a
+-b
++A
++B
+`,
+			wantOld:     "f.go",
+			wantNew:     "f.go",
+			wantAdded:   2,
+			wantDeleted: 2,
+			wantHunks:   1,
+		},
+		{
+			name: "added",
+			diff: `--- /dev/null
++++ b/new.go
+@@ -0,0 +1,2 @@
++package p
++
+`,
+			wantNew:   "new.go",
+			wantAdded: 2,
+			wantHunks: 1,
+		},
+		{
+			name: "removed",
+			diff: `--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package p
+`,
+			wantOld:     "old.go",
+			wantDeleted: 1,
+			wantHunks:   1,
+		},
+	}
+	for _, tc := range tests {
+		got := newFileDiffJSON(parseFileDiff(t, tc.diff).FileDiff)
+		if got.OldPath != tc.wantOld || got.NewPath != tc.wantNew {
+			t.Errorf("%s: got paths %q, %q, want %q, %q", tc.name, got.OldPath, got.NewPath, tc.wantOld, tc.wantNew)
+		}
+		if got.Added != tc.wantAdded || got.Deleted != tc.wantDeleted {
+			t.Errorf("%s: got +%d -%d, want +%d -%d", tc.name, got.Added, got.Deleted, tc.wantAdded, tc.wantDeleted)
+		}
+		if len(got.Hunks) != tc.wantHunks {
+			t.Errorf("%s: got %d hunks, want %d", tc.name, len(got.Hunks), tc.wantHunks)
+		}
+	}
+}
+
+func TestCountsJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]uint64
+		want   string
+	}{
+		{
+			name:   "all counted",
+			counts: map[string]uint64{"open": 3, "closed": 2, "merged": 1, "": 5, "unread": 0},
+			want:   `{"open":3,"closed":2,"merged":1,"all":5,"unread":0}`,
+		},
+		{
+			name:   "failed counts are null",
+			counts: map[string]uint64{"open": 0, "unread": 4},
+			want:   `{"open":0,"closed":null,"merged":null,"all":null,"unread":4}`,
+		},
+		{
+			name:   "unread is missing without notifications",
+			counts: map[string]uint64{"open": 3, "closed": 2, "": 5},
+			want:   `{"open":3,"closed":2,"merged":null,"all":5}`,
+		},
+	}
+	for _, tc := range tests {
+		var s state
+		s.Changes.ChangesNav.Counts = tc.counts
+		got, err := json.Marshal(newChangesDocument(s, "").Counts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/app/changes/route"
+	"dmitri.shuralyov.com/app/changes/search"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/issues"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultPerPage = 25  // Number of changes shown per page, unless specified otherwise.
+	maxPerPage     = 100 // Maximum number of changes that can be shown per page.
+)
+
+// pageOptions parses the current page and number of changes per page from query,
+// returning an error if the values are unsupported. page is 1-based.
+func pageOptions(query url.Values) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if v := query.Get(pageQueryKey); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("unsupported page value: %q", v)
+		}
+	}
+	if v := query.Get(perPageQueryKey); v != "" {
+		perPage, err = strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, fmt.Errorf("unsupported per_page value: %q (must be between 1 and %d)", v, maxPerPage)
+		}
+	}
+	return page, perPage, nil
+}
+
+// listQuery parses the selected state tab and the search query from query.
+// The returned search query includes the state of the selected tab,
+// unless it specifies a state itself, and the selected label facets.
+// The Unread tab's is:unread is always included, since it's independent of state.
+func listQuery(query url.Values) (route.StateTab, search.Query, error) {
+	tab, err := route.StateTabByName(query.Get(route.StateQueryKey))
+	if err != nil {
+		return route.StateTab{}, search.Query{}, err
+	}
+	q, err := search.Parse(query.Get(searchQueryKey))
+	if err != nil {
+		return route.StateTab{}, search.Query{}, err
+	}
+	switch {
+	case tab.Is == "unread":
+		if !q.Unread() {
+			q.Is = append(q.Is, tab.Is)
+		}
+	case len(q.Is) == 0 && tab.Is != "":
+		// The search query doesn't specify a state, so use the selected tab's.
+		q.Is = []string{tab.Is}
+	}
+	q.Labels = append(q.Labels, query[labelQueryKey]...)
+	return tab, q, nil
+}
+
+// listPage lists the given page of changes that match opt, with up to perPage changes per page.
+// page is 1-based.
+//
+// If the change service can list a page of changes natively (by implementing a ListPage method),
+// it's used. Otherwise, all changes are listed and the page is sliced out of the result.
+func listPage(ctx context.Context, service change.Service, repo string, opt change.ListOptions, page, perPage int) ([]change.Change, error) {
+	start := (page - 1) * perPage
+	if p, ok := service.(interface {
+		ListPage(ctx context.Context, repo string, opt change.ListOptions, start, length int) ([]change.Change, error)
+	}); ok {
+		return p.ListPage(ctx, repo, opt, start, perPage)
+	}
+	cs, err := service.List(ctx, repo, opt)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(cs, start, perPage), nil
+}
+
+// mergedCounter returns the change service's CountMerged method, if it can count merged changes natively.
+// Otherwise, ok is false, and merged changes aren't counted, since that would require
+// listing all closed changes on every page load.
+func mergedCounter(service change.Service) (countMerged func(ctx context.Context, repo string) (uint64, error), ok bool) {
+	c, ok := service.(interface {
+		CountMerged(ctx context.Context, repo string) (uint64, error)
+	})
+	if !ok {
+		return nil, false
+	}
+	return c.CountMerged, true
+}
+
+// listMatching lists all changes that match opt and search query q,
+// in the order specified by sortBy. Predicates of q that opt doesn't capture are applied here.
+// unread is the set of IDs of changes with unread notifications, used if q has is:unread.
+// If q has reviewer qualifiers, the timelines of changes that match the rest of q are fetched.
+//
+// sortBy is one of availableSortOptions values, or empty string for the change service's default order.
+// If the change service can sort natively (by implementing a ListSorted method), it's used.
+// Otherwise, changes are sorted here.
+func listMatching(ctx context.Context, service change.Service, repo string, opt change.ListOptions, q search.Query, unread map[uint64]struct{}, sortBy string) ([]change.Change, error) {
+	var (
+		cs     []change.Change
+		sorted = sortBy == ""
+		err    error
+	)
+	if s, ok := service.(sortedLister); ok && !sorted {
+		cs, err = s.ListSorted(ctx, repo, opt, sortBy)
+		sorted = true
+	} else {
+		cs, err = service.List(ctx, repo, opt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var matched []change.Change
+	for _, c := range cs {
+		if _, ok := unread[c.ID]; !q.Match(c) || !q.MatchUnread(ok) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	if len(q.Reviewers) > 0 {
+		matched, err = matchTimelines(ctx, service, repo, q, matched)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !sorted {
+		err := sortChanges(matched, sortBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// maxTimelineFetches is the maximum number of timelines that matchTimelines
+// fetches concurrently, so that the change service isn't flooded with requests.
+const maxTimelineFetches = 8
+
+// matchTimelines returns the changes of cs whose timelines match q, keeping their order.
+// It fetches the timeline of every change in cs, up to maxTimelineFetches at a time.
+func matchTimelines(ctx context.Context, service change.Service, repo string, q search.Query, cs []change.Change) ([]change.Change, error) {
+	var (
+		match = make([]bool, len(cs)) // Whether cs[i] matches.
+		sem   = make(chan struct{}, maxTimelineFetches)
+	)
+	g, groupCtx := errgroup.WithContext(ctx)
+Fetch:
+	for i, c := range cs {
+		select {
+		case sem <- struct{}{}:
+		case <-groupCtx.Done():
+			// A fetch failed or the request was canceled, so don't start more.
+			break Fetch
+		}
+		i, c := i, c
+		g.Go(func() error {
+			defer func() { <-sem }()
+			timeline, err := service.ListTimeline(groupCtx, repo, c.ID, nil)
+			if err != nil {
+				return fmt.Errorf("changes.ListTimeline(%d): %v", c.ID, err)
+			}
+			match[i] = q.MatchTimeline(timeline)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var matched []change.Change
+	for i, c := range cs {
+		if match[i] {
+			matched = append(matched, c)
+		}
+	}
+	return matched, nil
+}
+
+// sortOptions are the supported orders for sorting changes.
+var sortOptions = []component.SortOption{
+	{Value: "newest", Name: "Newest"},
+	{Value: "oldest", Name: "Oldest"},
+	{Value: "most-commented", Name: "Most commented"},
+	{Value: "recently-updated", Name: "Recently updated"},
+	{Value: "title", Name: "Title"},
+}
+
+// sortedLister is implemented by change services that can list changes
+// in one of sortOptions orders natively.
+type sortedLister interface {
+	ListSorted(ctx context.Context, repo string, opt change.ListOptions, sortBy string) ([]change.Change, error)
+}
+
+// availableSortOptions returns the sortOptions that are available for service.
+//
+// Sorting by "recently-updated" is only available if service can sort natively,
+// since change.Change doesn't include the time it was last updated, so sorting
+// by it here would require fetching the timeline of every change.
+func availableSortOptions(service change.Service) []component.SortOption {
+	if _, ok := service.(sortedLister); ok {
+		return sortOptions
+	}
+	var options []component.SortOption
+	for _, o := range sortOptions {
+		if o.Value == "recently-updated" {
+			continue
+		}
+		options = append(options, o)
+	}
+	return options
+}
+
+// sortOption parses the sort order from query, returning an error if the value isn't one of options.
+// It returns empty string if no sort order is specified.
+func sortOption(query url.Values, options []component.SortOption) (string, error) {
+	sortBy := query.Get(sortQueryKey)
+	if sortBy == "" {
+		return "", nil
+	}
+	for _, o := range options {
+		if o.Value == sortBy {
+			return sortBy, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported sort value: %q", sortBy)
+}
+
+// sortChanges sorts cs in the order specified by sortBy.
+// Sorting by "recently-updated" isn't supported, see availableSortOptions.
+func sortChanges(cs []change.Change, sortBy string) error {
+	var less func(a, b change.Change) bool
+	switch sortBy {
+	case "newest":
+		less = func(a, b change.Change) bool { return a.CreatedAt.After(b.CreatedAt) }
+	case "oldest":
+		less = func(a, b change.Change) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "most-commented":
+		less = func(a, b change.Change) bool { return a.Replies > b.Replies }
+	case "title":
+		less = func(a, b change.Change) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	default:
+		return fmt.Errorf("unsupported sort value: %q", sortBy)
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return less(cs[i], cs[j]) })
+	return nil
+}
+
+// pageOf returns up to length changes from cs, starting at index start.
+func pageOf(cs []change.Change, start, length int) []change.Change {
+	if start >= len(cs) {
+		return nil
+	}
+	end := start + length
+	if end > len(cs) {
+		end = len(cs)
+	}
+	return cs[start:end]
+}
+
+// pageCount returns the number of pages needed to display total changes,
+// with perPage changes per page.
+func pageCount(total uint64, perPage int) int {
+	return int((total + uint64(perPage) - 1) / uint64(perPage))
+}
+
+// labelFacets returns label facets for the labels of cs, sorted by descending count.
+// Labels in selected are marked as selected, and included even if none of cs have them.
+func labelFacets(cs []change.Change, selected []string) []component.LabelFacet {
+	var facets []component.LabelFacet
+	index := make(map[string]int) // Lowercase label name -> index in facets.
+	for _, c := range cs {
+		for _, l := range c.Labels {
+			key := strings.ToLower(l.Name)
+			i, ok := index[key]
+			if !ok {
+				i = len(facets)
+				index[key] = i
+				facets = append(facets, component.LabelFacet{Label: l})
+			}
+			facets[i].Count++
+		}
+	}
+	for _, name := range selected {
+		key := strings.ToLower(name)
+		i, ok := index[key]
+		if !ok {
+			i = len(facets)
+			index[key] = i
+			facets = append(facets, component.LabelFacet{Label: issues.Label{Name: name}})
+		}
+		facets[i].Selected = true
+	}
+	sort.SliceStable(facets, func(i, j int) bool {
+		if facets[i].Count == facets[j].Count {
+			return strings.ToLower(facets[i].Label.Name) < strings.ToLower(facets[j].Label.Name)
+		}
+		return facets[i].Count > facets[j].Count
+	})
+	return facets
+}
@@ -0,0 +1,334 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/app/changes/search"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/issues"
+	"github.com/shurcooL/users"
+)
+
+func TestPageOptions(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantPage    int
+		wantPerPage int
+		wantErr     bool
+	}{
+		{in: "", wantPage: 1, wantPerPage: defaultPerPage},
+		{in: "page=3", wantPage: 3, wantPerPage: defaultPerPage},
+		{in: "page=2&per_page=10", wantPage: 2, wantPerPage: 10},
+		{in: "per_page=100", wantPage: 1, wantPerPage: maxPerPage},
+		{in: "page=0", wantErr: true},
+		{in: "page=-1", wantErr: true},
+		{in: "page=two", wantErr: true},
+		{in: "per_page=0", wantErr: true},
+		{in: "per_page=101", wantErr: true},
+	}
+	for _, tc := range tests {
+		query, err := url.ParseQuery(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, perPage, err := pageOptions(query)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("pageOptions(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if page != tc.wantPage || perPage != tc.wantPerPage {
+			t.Errorf("pageOptions(%q): got %d, %d, want %d, %d", tc.in, page, perPage, tc.wantPage, tc.wantPerPage)
+		}
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantTab string
+		wantIs  []string
+		wantErr bool
+	}{
+		{in: "", wantTab: "", wantIs: []string{"open"}},
+		{in: "state=closed", wantTab: "closed", wantIs: []string{"closed"}},
+		{in: "state=all", wantTab: "all", wantIs: nil},
+		{in: "state=closed&q=is:merged", wantTab: "closed", wantIs: []string{"merged"}},
+		{in: "state=unread", wantTab: "unread", wantIs: []string{"unread"}},
+		{in: "state=unread&q=is:open", wantTab: "unread", wantIs: []string{"open", "unread"}},
+		{in: "state=unread&q=is:unread", wantTab: "unread", wantIs: []string{"unread"}},
+		{in: "state=bogus", wantErr: true},
+		{in: "q=is:bogus", wantErr: true},
+	}
+	for _, tc := range tests {
+		query, err := url.ParseQuery(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tab, q, err := listQuery(query)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("listQuery(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if tab.Name != tc.wantTab || !reflect.DeepEqual(q.Is, tc.wantIs) {
+			t.Errorf("listQuery(%q): got tab %q, is %q, want tab %q, is %q", tc.in, tab.Name, q.Is, tc.wantTab, tc.wantIs)
+		}
+	}
+}
+
+func TestPageOf(t *testing.T) {
+	cs := []change.Change{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}}
+	tests := []struct {
+		start, length int
+		want          []uint64
+	}{
+		{start: 0, length: 2, want: []uint64{1, 2}},
+		{start: 2, length: 2, want: []uint64{3, 4}},
+		{start: 4, length: 2, want: []uint64{5}},
+		{start: 0, length: 10, want: []uint64{1, 2, 3, 4, 5}},
+		{start: 5, length: 2, want: nil},
+		{start: 10, length: 2, want: nil},
+	}
+	for _, tc := range tests {
+		var got []uint64
+		for _, c := range pageOf(cs, tc.start, tc.length) {
+			got = append(got, c.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("pageOf(cs, %d, %d): got %v, want %v", tc.start, tc.length, got, tc.want)
+		}
+	}
+}
+
+func TestPageCount(t *testing.T) {
+	tests := []struct {
+		total   uint64
+		perPage int
+		want    int
+	}{
+		{total: 0, perPage: 25, want: 0},
+		{total: 1, perPage: 25, want: 1},
+		{total: 25, perPage: 25, want: 1},
+		{total: 26, perPage: 25, want: 2},
+		{total: 100, perPage: 1, want: 100},
+	}
+	for _, tc := range tests {
+		if got := pageCount(tc.total, tc.perPage); got != tc.want {
+			t.Errorf("pageCount(%d, %d): got %d, want %d", tc.total, tc.perPage, got, tc.want)
+		}
+	}
+}
+
+func TestSortChanges(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2018, 3, d, 0, 0, 0, 0, time.UTC) }
+	cs := []change.Change{
+		{ID: 1, Title: "b", CreatedAt: day(2), Replies: 1},
+		{ID: 2, Title: "C", CreatedAt: day(1), Replies: 5},
+		{ID: 3, Title: "a", CreatedAt: day(3), Replies: 1},
+	}
+	tests := []struct {
+		sortBy  string
+		want    []uint64
+		wantErr bool
+	}{
+		{sortBy: "newest", want: []uint64{3, 1, 2}},
+		{sortBy: "oldest", want: []uint64{2, 1, 3}},
+		{sortBy: "most-commented", want: []uint64{2, 1, 3}}, // Ties keep their order.
+		{sortBy: "title", want: []uint64{3, 1, 2}},
+		{sortBy: "recently-updated", wantErr: true}, // Only supported natively.
+		{sortBy: "popular", wantErr: true},
+	}
+	for _, tc := range tests {
+		sorted := append([]change.Change(nil), cs...)
+		err := sortChanges(sorted, tc.sortBy)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("sortChanges(%q): got error %v, want error: %v", tc.sortBy, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		var got []uint64
+		for _, c := range sorted {
+			got = append(got, c.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("sortChanges(%q): got %v, want %v", tc.sortBy, got, tc.want)
+		}
+	}
+}
+
+// sortingService is a change service that can sort natively.
+type sortingService struct {
+	change.Service
+}
+
+func (sortingService) ListSorted(context.Context, string, change.ListOptions, string) ([]change.Change, error) {
+	return nil, nil
+}
+
+func TestSortOption(t *testing.T) {
+	tests := []struct {
+		service change.Service
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{service: nil, in: "", want: ""},
+		{service: nil, in: "sort=newest", want: "newest"},
+		{service: nil, in: "sort=recently-updated", wantErr: true},
+		{service: sortingService{}, in: "sort=recently-updated", want: "recently-updated"},
+		{service: sortingService{}, in: "sort=popular", wantErr: true},
+	}
+	for _, tc := range tests {
+		query, err := url.ParseQuery(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := sortOption(query, availableSortOptions(tc.service))
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("sortOption(%q) with %T: got error %v, want error: %v", tc.in, tc.service, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("sortOption(%q) with %T: got %q, want %q", tc.in, tc.service, got, tc.want)
+		}
+	}
+}
+
+func TestLabelFacets(t *testing.T) {
+	label := func(name string) issues.Label { return issues.Label{Name: name} }
+	cs := []change.Change{
+		{Labels: []issues.Label{label("bug"), label("NeedsFix")}},
+		{Labels: []issues.Label{label("Bug")}},
+		{Labels: []issues.Label{label("docs")}},
+		{},
+	}
+	tests := []struct {
+		name     string
+		selected []string
+		want     []component.LabelFacet
+	}{
+		{
+			name: "none selected",
+			want: []component.LabelFacet{
+				{Label: label("bug"), Count: 2},
+				{Label: label("docs"), Count: 1},
+				{Label: label("NeedsFix"), Count: 1},
+			},
+		},
+		{
+			name:     "selected case-insensitively",
+			selected: []string{"BUG"},
+			want: []component.LabelFacet{
+				{Label: label("bug"), Count: 2, Selected: true},
+				{Label: label("docs"), Count: 1},
+				{Label: label("NeedsFix"), Count: 1},
+			},
+		},
+		{
+			name:     "selected without changes",
+			selected: []string{"wontfix"},
+			want: []component.LabelFacet{
+				{Label: label("bug"), Count: 2},
+				{Label: label("docs"), Count: 1},
+				{Label: label("NeedsFix"), Count: 1},
+				{Label: label("wontfix"), Selected: true},
+			},
+		},
+	}
+	for _, tc := range tests {
+		if got := labelFacets(cs, tc.selected); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: labelFacets:\ngot  %+v\nwant %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// timelineService is a change service that lists changes and their timelines.
+// It records the maximum number of timelines fetched concurrently.
+type timelineService struct {
+	change.Service
+	changes   []change.Change
+	timelines map[uint64][]interface{} // Change ID -> timeline. Missing timelines fail to be fetched.
+
+	mu                  sync.Mutex
+	inFlight, maxFlight int
+}
+
+func (s *timelineService) List(context.Context, string, change.ListOptions) ([]change.Change, error) {
+	return s.changes, nil
+}
+
+func (s *timelineService) ListTimeline(_ context.Context, _ string, id uint64, _ *change.ListTimelineOptions) ([]interface{}, error) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond) // Give other fetches a chance to overlap.
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+
+	timeline, ok := s.timelines[id]
+	if !ok {
+		return nil, errors.New("no timeline")
+	}
+	return timeline, nil
+}
+
+func TestListMatchingReviewers(t *testing.T) {
+	reviewedBy := func(login string) []interface{} {
+		return []interface{}{change.Review{User: users.User{Login: login}}}
+	}
+	service := &timelineService{timelines: make(map[uint64][]interface{})}
+	for id := uint64(1); id <= 50; id++ {
+		service.changes = append(service.changes, change.Change{ID: id, State: change.OpenState})
+		switch id % 3 {
+		case 0:
+			service.timelines[id] = reviewedBy("alice")
+		default:
+			service.timelines[id] = reviewedBy("bob")
+		}
+	}
+	service.changes = append(service.changes, change.Change{ID: 100, State: change.ClosedState}) // Has no timeline.
+
+	tests := []struct {
+		in      string
+		want    []uint64
+		wantErr bool
+	}{
+		{in: "is:open reviewer:alice", want: []uint64{3, 6, 9, 12, 15, 18, 21, 24, 27, 30, 33, 36, 39, 42, 45, 48}},
+		{in: "is:open reviewer:alice reviewer:bob", want: nil},
+		{in: "reviewer:alice", wantErr: true}, // The timeline of change 100 can't be fetched.
+	}
+	for _, tc := range tests {
+		q, err := search.Parse(tc.in)
+		if err != nil {
+			t.Fatalf("search.Parse(%q): %v", tc.in, err)
+		}
+		opt, _ := q.ListOptions()
+		cs, err := listMatching(context.Background(), service, "repo", opt, q, nil, "")
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("listMatching(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		var got []uint64
+		for _, c := range cs {
+			got = append(got, c.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("listMatching(%q): got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	if service.maxFlight > maxTimelineFetches {
+		t.Errorf("got %d timelines fetched concurrently, want at most %d", service.maxFlight, maxTimelineFetches)
+	}
+}
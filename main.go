@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
@@ -20,10 +21,10 @@ import (
 	"dmitri.shuralyov.com/app/changes/assets"
 	"dmitri.shuralyov.com/app/changes/common"
 	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/app/changes/route"
 	"dmitri.shuralyov.com/service/change"
 	statepkg "dmitri.shuralyov.com/state"
 	"github.com/dustin/go-humanize"
-	"github.com/shurcooL/github_flavored_markdown"
 	"github.com/shurcooL/htmlg"
 	"github.com/shurcooL/httperror"
 	"github.com/shurcooL/httpfs/html/vfstemplate"
@@ -35,6 +36,7 @@ import (
 	"github.com/shurcooL/users"
 	"github.com/sourcegraph/go-diff/diff"
 	"golang.org/x/net/html"
+	"golang.org/x/sync/errgroup"
 )
 
 // TODO: Find a better way for changes to be able to ensure registration of a top-level route:
@@ -61,6 +63,9 @@ import (
 // 		changesApp.ServeHTTP(w, req)
 // 	})
 //
+// Pages are also available as JSON documents, for use by other tools. They're served
+// when the request has an "Accept: application/json" header or a "format=json" query parameter.
+//
 // An HTTP API must be available (currently, only EditComment endpoint is used):
 //
 // 	// Register HTTP API endpoints.
@@ -108,6 +113,24 @@ type Options struct {
 
 	// BodyTop provides components to include on top of <body> of page rendered for req. It can be nil.
 	BodyTop func(*http.Request, common.State) ([]htmlg.Component, error)
+
+	// SignInURL, if not empty, is the URL of a page for signing in.
+	// It's linked to from prompts for unauthenticated users to sign in.
+	SignInURL string
+
+	// IsBot, if not nil, reports whether a user is a bot, for hiding their timeline items.
+	// If nil, users whose login ends with "[bot]" are considered bots.
+	IsBot func(users.User) bool
+
+	// EventRenderers, if not nil, render timeline events with payloads of registered types.
+	// They're useful for payload types that aren't in the change package, and take precedence
+	// over built-in rendering. Events with other unknown payloads get a generic description.
+	EventRenderers component.EventRenderers
+
+	// ApprovalRule, if not nil, reports whether a change has the approvals it needs,
+	// given the latest votes of its reviewers, along with a short explanation
+	// for the review summary. If nil, DefaultApprovalRule is used.
+	ApprovalRule func(votes []component.ReviewerVote) (approved bool, explanation string)
 }
 
 // handler handles all requests to changes. It acts like a request multiplexer,
@@ -153,11 +176,29 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
+	// Pages are served as either HTML or JSON, depending on the Accept header.
+	w.Header().Add("Vary", "Accept")
+
 	// Handle "/".
 	if req.URL.Path == "/" {
 		return h.ChangesHandler(w, req)
 	}
 
+	// Handle "/feed.atom".
+	if req.URL.Path == "/feed.atom" {
+		return h.ChangesFeedHandler(w, req)
+	}
+
+	// Handle "/preview".
+	if req.URL.Path == "/preview" {
+		return h.PreviewHandler(w, req)
+	}
+
+	// Handle "/mark-all-read".
+	if req.URL.Path == "/mark-all-read" {
+		return h.MarkAllReadHandler(w, req)
+	}
+
 	// Handle "/mock".
 	if req.URL.Path == "/mock" {
 		return h.MockHandler(w, req)
@@ -174,6 +215,31 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) error {
 	case len(elems) == 1:
 		return h.ChangeHandler(w, req, changeID)
 
+	// "/{changeID}/feed.atom".
+	case len(elems) == 2 && elems[1] == "feed.atom":
+		return h.ChangeFeedHandler(w, req, changeID)
+
+	// "/{changeID}/events".
+	case len(elems) == 2 && elems[1] == "events":
+		return h.EventsHandler(w, req, changeID)
+
+	// "/{changeID}/timeline".
+	case len(elems) == 2 && elems[1] == "timeline":
+		return h.TimelineHandler(w, req, changeID)
+
+	// "/{changeID}/comments".
+	case len(elems) == 2 && elems[1] == "comments":
+		return h.CommentsHandler(w, req, changeID)
+
+	// "/{changeID}/review".
+	case len(elems) == 2 && elems[1] == "review":
+		return h.ReviewHandler(w, req, changeID)
+
+	// "/{changeID}/threads/{commentID}".
+	case len(elems) == 3 && elems[1] == "threads":
+		commentID := elems[2]
+		return h.ThreadHandler(w, req, changeID, commentID)
+
 	// "/{changeID}/commits".
 	case len(elems) == 2 && elems[1] == "commits":
 		return h.ChangeCommitsHandler(w, req, changeID)
@@ -200,39 +266,188 @@ func (h *handler) ChangesHandler(w http.ResponseWriter, req *http.Request) error
 	if err != nil {
 		return err
 	}
-	filter, err := stateFilter(req.URL.Query())
+	tab, q, err := listQuery(req.URL.Query())
 	if err != nil {
 		return httperror.BadRequest{Err: err}
 	}
-	is, err := h.cs.List(req.Context(), state.RepoSpec, change.ListOptions{Filter: filter})
+	if q.Unread() && h.Notifications == nil {
+		return httperror.BadRequest{Err: errors.New("is:unread requires notifications, which aren't enabled")}
+	}
+	labels := req.URL.Query()[labelQueryKey] // Selected label facets.
+	opt, complete := q.ListOptions()
+	sortOpts := availableSortOptions(h.cs)
+	sortBy, err := sortOption(req.URL.Query(), sortOpts)
 	if err != nil {
-		return err
+		return httperror.BadRequest{Err: err}
 	}
-	openCount, err := h.cs.Count(req.Context(), state.RepoSpec, change.ListOptions{Filter: change.FilterOpen})
+	page, perPage, err := pageOptions(req.URL.Query())
 	if err != nil {
-		return fmt.Errorf("changes.Count(open): %v", err)
+		return httperror.BadRequest{Err: err}
+	}
+	var (
+		paged = complete && sortBy == "" // Whether only the current page is listed.
+		all   []change.Change            // All matching changes. Only set if !paged.
+		is    []change.Change            // Changes on the current page.
+		total uint64                     // Total number of changes on all pages.
+
+		// Counts and unread notifications are optional, so errors fetching them
+		// are recorded separately, rather than failing the entire page.
+		openCount, closedCount, mergedCount uint64
+		openErr, closedErr, mergedErr       error
+		unreadThreads                       map[uint64]struct{} // Set of unread thread IDs. Nil if unknown.
+		unreadErr                           error
+	)
+	if q.Unread() {
+		// Unread notifications are needed to list the changes, so they're not optional.
+		unreadThreads, err = state.unreadThreads(req.Context(), h.Notifications, h.cs)
+		if err != nil {
+			return err
+		}
+	}
+	// unreadForList is what listMatching uses. Unlike unreadThreads, it isn't written
+	// by the goroutine that fetches unread notifications concurrently.
+	unreadForList := unreadThreads // Only non-nil if q.Unread().
+
+	// Fetch the changes, their counts, and unread notifications concurrently.
+	g, ctx := errgroup.WithContext(req.Context())
+	g.Go(func() error {
+		if paged {
+			var err error
+			is, err = listPage(ctx, h.cs, state.RepoSpec, opt, page, perPage)
+			return err
+		}
+		// The search query has predicates that the change service can't handle,
+		// or a sort order is specified, so list all changes and slice out the page afterwards.
+		var err error
+		all, err = listMatching(ctx, h.cs, state.RepoSpec, opt, q, unreadForList, sortBy)
+		if err != nil {
+			return err
+		}
+		total = uint64(len(all))
+		is = pageOf(all, (page-1)*perPage, perPage)
+		return nil
+	})
+	g.Go(func() error {
+		openCount, openErr = h.cs.Count(ctx, state.RepoSpec, change.ListOptions{Filter: change.FilterOpen})
+		if openErr != nil {
+			openErr = fmt.Errorf("changes.Count(open): %v", openErr)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		closedCount, closedErr = h.cs.Count(ctx, state.RepoSpec, change.ListOptions{Filter: change.FilterClosedMerged})
+		if closedErr != nil {
+			closedErr = fmt.Errorf("changes.Count(closed): %v", closedErr)
+		}
+		return nil
+	})
+	countMerged, canCountMerged := mergedCounter(h.cs)
+	if canCountMerged {
+		g.Go(func() error {
+			mergedCount, mergedErr = countMerged(ctx, state.RepoSpec)
+			if mergedErr != nil {
+				mergedErr = fmt.Errorf("changes.CountMerged: %v", mergedErr)
+			}
+			return nil
+		})
 	}
-	closedCount, err := h.cs.Count(req.Context(), state.RepoSpec, change.ListOptions{Filter: change.FilterClosedMerged})
+	if h.Notifications != nil && !q.Unread() {
+		g.Go(func() error {
+			unreadThreads, unreadErr = state.unreadThreads(ctx, h.Notifications, h.cs)
+			return nil
+		})
+	}
+	err = g.Wait()
 	if err != nil {
-		return fmt.Errorf("changes.Count(closed): %v", err)
+		return err
+	}
+	counts := make(map[string]uint64) // State tab Is value -> count of changes.
+	if openErr == nil {
+		counts["open"] = openCount
+	} else {
+		state.addFailure("the number of open changes", openErr)
+	}
+	if closedErr == nil {
+		counts["closed"] = closedCount
+	} else {
+		state.addFailure("the number of closed changes", closedErr)
+	}
+	switch {
+	case !canCountMerged:
+		// The Merged tab is displayed without a count.
+	case mergedErr == nil:
+		counts["merged"] = mergedCount
+	default:
+		state.addFailure("the number of merged changes", mergedErr)
+	}
+	if openErr == nil && closedErr == nil {
+		counts[""] = openCount + closedCount
+	}
+	if h.Notifications != nil && unreadErr == nil {
+		counts["unread"] = uint64(len(unreadThreads))
+	} else if unreadErr != nil {
+		state.addFailure("unread notifications", unreadErr)
 	}
 	var es []component.ChangeEntry
 	for _, i := range is {
-		es = append(es, component.ChangeEntry{Change: i, BaseURI: state.BaseURI})
-	}
-	if h.Notifications != nil {
-		es = state.augmentUnread(req.Context(), es, h.Notifications, h.cs)
+		_, unread := unreadThreads[i.ID]
+		es = append(es, component.ChangeEntry{Change: i, Unread: unread, BaseURI: state.BaseURI})
+	}
+	pagination := component.Pagination{
+		Page:         page,
+		Path:         state.BaseURI + state.ReqPath,
+		Query:        req.URL.Query(),
+		PageQueryKey: pageQueryKey,
+	}
+	if paged {
+		// Only the current page is listed, so label facets are built from it,
+		// and their counts aren't displayed since they'd only cover this page.
+		all = is
+
+		// The total is the count of changes in the listed state.
+		var listedState string
+		if len(q.Is) == 1 {
+			listedState = q.Is[0]
+		}
+		if count, ok := counts[listedState]; ok {
+			pagination.PageCount = pageCount(count, perPage)
+		} else {
+			// The total is unknown, so link to the next page only if the current one is full.
+			pagination.HasNext = len(es) == perPage
+		}
+	} else {
+		pagination.PageCount = pageCount(total, perPage)
 	}
 	state.Changes = component.Changes{
 		ChangesNav: component.ChangesNav{
-			OpenCount:     openCount,
-			ClosedCount:   closedCount,
-			Path:          state.BaseURI + state.ReqPath,
-			Query:         req.URL.Query(),
-			StateQueryKey: stateQueryKey,
+			Counts:         counts,
+			NoMergedCount:  !canCountMerged,
+			UnreadTab:      h.Notifications != nil,
+			Path:           state.BaseURI + state.ReqPath,
+			Query:          req.URL.Query(),
+			PageQueryKey:   pageQueryKey,
+			SearchQueryKey: searchQueryKey,
+			SortQueryKey:   sortQueryKey,
+			SortOptions:    sortOpts,
 		},
-		Filter:  filter,
-		Entries: es,
+		StateTab:   tab,
+		Search:     req.URL.Query().Get(searchQueryKey),
+		Entries:    es,
+		Pagination: pagination,
+	}
+	if tab.Is == "unread" && len(unreadThreads) > 0 {
+		state.Changes.MarkAllReadURL = state.BaseURI + "/mark-all-read"
+	}
+	state.LabelFacets = component.LabelFacets{
+		Facets:        labelFacets(all, labels),
+		NoCounts:      paged,
+		Path:          state.BaseURI + state.ReqPath,
+		Query:         req.URL.Query(),
+		LabelQueryKey: labelQueryKey,
+		PageQueryKey:  pageQueryKey,
+	}
+	if wantsJSON(req) {
+		return writeJSON(w, newChangesDocument(state, sortBy))
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err = h.static.ExecuteTemplate(w, "changes.html.tmpl", &state)
@@ -243,49 +458,54 @@ func (h *handler) ChangesHandler(w http.ResponseWriter, req *http.Request) error
 }
 
 const (
-	// stateQueryKey is name of query key for controlling change state filter.
-	stateQueryKey = "state"
-)
+	// pageQueryKey is name of query key for controlling the current page of changes.
+	pageQueryKey = "page"
 
-// stateFilter parses the change state filter from query,
-// returning an error if the value is unsupported.
-func stateFilter(query url.Values) (change.StateFilter, error) {
-	selectedTabName := query.Get(stateQueryKey)
-	switch selectedTabName {
-	case "":
-		return change.FilterOpen, nil
-	case "closed":
-		return change.FilterClosedMerged, nil
-	case "all":
-		return change.FilterAll, nil
-	default:
-		return "", fmt.Errorf("unsupported state filter value: %q", selectedTabName)
-	}
-}
+	// perPageQueryKey is name of query key for controlling how many changes are shown per page.
+	perPageQueryKey = "per_page"
+
+	// searchQueryKey is name of query key for the search query that changes must match.
+	searchQueryKey = "q"
 
-func (s state) augmentUnread(ctx context.Context, es []component.ChangeEntry, notificationService notifications.Service, changeService change.Service) []component.ChangeEntry {
+	// sortQueryKey is name of query key for controlling the order of changes.
+	sortQueryKey = "sort"
+
+	// labelQueryKey is name of query key for a selected label facet. It can be repeated.
+	labelQueryKey = "label"
+
+	// timelineQueryKey is name of query key for controlling whether long timelines are collapsed.
+	// The only supported value is "all", which displays all timeline items.
+	timelineQueryKey = "timeline"
+
+	// diffQueryKey is name of query key for controlling how file diffs are displayed.
+	// It's one of "unified", "split". The selected view is remembered in a cookie.
+	diffQueryKey = "diff"
+)
+
+// unreadThreads returns the set of change IDs that have unread notifications
+// for the current user. It returns nil if the change service doesn't support
+// notifications, or if there's no authenticated user.
+func (s state) unreadThreads(ctx context.Context, notificationService notifications.Service, changeService change.Service) (map[uint64]struct{}, error) {
 	tt, ok := changeService.(interface {
 		ThreadType(repo string) string
 	})
 	if !ok {
-		log.Println("augmentUnread: change service doesn't implement ThreadType")
-		return es
+		log.Println("unreadThreads: change service doesn't implement ThreadType")
+		return nil, nil
 	}
 	threadType := tt.ThreadType(s.RepoSpec)
 
 	if s.CurrentUser.ID == 0 {
 		// Unauthenticated user cannot have any unread changes.
-		return es
+		return nil, nil
 	}
 
-	// TODO: Consider starting to do this in background in parallel with is.List.
 	ns, err := notificationService.List(ctx, notifications.ListOptions{
 		Repo: &notifications.RepoSpec{URI: s.RepoSpec},
 		All:  false,
 	})
 	if err != nil {
-		log.Println("augmentUnread: failed to notifications.List:", err)
-		return es
+		return nil, fmt.Errorf("notifications.List: %v", err)
 	}
 
 	unreadThreads := make(map[uint64]struct{}) // Set of unread thread IDs.
@@ -297,12 +517,114 @@ func (s state) augmentUnread(ctx context.Context, es []component.ChangeEntry, no
 		}
 		unreadThreads[n.ThreadID] = struct{}{}
 	}
+	return unreadThreads, nil
+}
+
+// maxPreviewSize is the maximum size of Markdown that can be previewed, in bytes.
+const maxPreviewSize = 1 << 20
+
+// PreviewHandler is the handler for "/preview" endpoint.
+// It renders the Markdown in the request body as sanitized HTML,
+// the same way it's rendered on pages. The optional "change" query parameter
+// specifies the ID of the change whose page the Markdown is written on,
+// so that references to its commits and participants are linked.
+func (h *handler) PreviewHandler(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodPost {
+		return httperror.Method{Allowed: []string{http.MethodPost}}
+	}
+	state := state{State: common.State{
+		BaseURI:  req.Context().Value(BaseURIContextKey).(string),
+		RepoSpec: req.Context().Value(RepoSpecContextKey).(string),
+	}}
+	var ts []interface{}
+	if changeParam := req.URL.Query().Get("change"); changeParam != "" {
+		changeID, err := strconv.ParseUint(changeParam, 10, 64)
+		if err != nil {
+			return httperror.BadRequest{Err: fmt.Errorf("invalid change ID %q", changeParam)}
+		}
+		state.ChangeID = changeID
+		state.Change, err = h.cs.Get(req.Context(), state.RepoSpec, state.ChangeID)
+		if err != nil {
+			return err
+		}
+		ts, err = h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
+		if err != nil {
+			// Mentions of participants aren't linked, but the preview is still useful.
+			log.Println("PreviewHandler: changes.ListTimeline:", err)
+		}
+		if h.us != nil {
+			if user, err := h.us.GetAuthenticated(req.Context()); err == nil {
+				state.CurrentUser = user
+			}
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxPreviewSize+1))
+	if err != nil {
+		return httperror.BadRequest{Err: fmt.Errorf("reading request body: %v", err)}
+	}
+	if len(body) > maxPreviewSize {
+		return httperror.HTTP{Code: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("Markdown is larger than %d bytes", maxPreviewSize)}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = io.WriteString(w, string(gfm(h.changeLinker(req.Context(), &state, ts), string(body))))
+	return err
+}
+
+// checkSameOrigin protects state-changing form submissions from cross-site request forgery.
+// It returns an error unless req comes from a page of the same host, as reported by the
+// Origin header, or by the Referer header if the browser didn't send an Origin.
+func checkSameOrigin(req *http.Request) error {
+	source := req.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return httperror.HTTP{Code: http.StatusForbidden, Err: errors.New("request has neither Origin nor Referer header")}
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host != req.Host {
+		return httperror.HTTP{Code: http.StatusForbidden, Err: fmt.Errorf("cross-origin request from %q", source)}
+	}
+	return nil
+}
 
-	for i, e := range es {
-		_, unread := unreadThreads[e.Change.ID]
-		es[i].Unread = unread
+// MarkAllReadHandler is the handler for "/mark-all-read" endpoint.
+// It marks all changes as read for the current user, then redirects to the unread tab.
+func (h *handler) MarkAllReadHandler(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodPost {
+		return httperror.Method{Allowed: []string{http.MethodPost}}
+	}
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+	if h.Notifications == nil {
+		return httperror.HTTP{Code: http.StatusNotFound, Err: errors.New("notifications aren't enabled")}
+	}
+	state, err := h.state(req, 0)
+	if err != nil {
+		return err
+	}
+	if state.CurrentUser.ID == 0 {
+		return os.ErrPermission
+	}
+	tt, ok := h.cs.(interface {
+		ThreadType(repo string) string
+	})
+	if !ok {
+		return fmt.Errorf("change service doesn't implement ThreadType")
+	}
+	threadType := tt.ThreadType(state.RepoSpec)
+	unreadThreads, err := state.unreadThreads(req.Context(), h.Notifications, h.cs)
+	if err != nil {
+		return err
+	}
+	for threadID := range unreadThreads {
+		err := h.Notifications.MarkRead(req.Context(), notifications.RepoSpec{URI: state.RepoSpec}, threadType, threadID)
+		if err != nil {
+			return fmt.Errorf("notifications.MarkRead(%d): %v", threadID, err)
+		}
 	}
-	return es
+	return httperror.Redirect{URL: state.BaseURI + "?" + route.StateQueryKey + "=unread"}
 }
 
 func (h *handler) MockHandler(w http.ResponseWriter, req *http.Request) error {
@@ -366,24 +688,51 @@ func (h *handler) ChangeHandler(w http.ResponseWriter, req *http.Request, change
 	}
 	ts, err := h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
 	if err != nil {
-		return fmt.Errorf("changes.ListTimeline: %v", err)
+		state.addFailure("the timeline", fmt.Errorf("changes.ListTimeline: %v", err))
 	}
-	if h.Notifications != nil {
+	if h.Notifications != nil && !wantsJSON(req) {
+		// Only mark the change as read when it's viewed by a person.
 		err := state.markRead(req.Context(), h.Notifications, h.cs)
 		if err != nil {
 			log.Println("ChangeHandler: failed to markRead:", err)
 		}
 	}
+	state.Filter, err = parseTimelineFilter(req.URL.Query())
+	if err != nil {
+		return httperror.BadRequest{Err: err}
+	}
 	var timeline []timelineItem
 	for _, item := range ts {
 		timeline = append(timeline, timelineItem{item})
 	}
 	sort.Sort(byCreatedAtID(timeline))
+	state.Authors = timelineAuthors(timeline)
+	state.ReviewSummary = h.reviewSummary(timeline)
+	sidebar := h.changeSidebar(req.Context(), &state, timeline)
+	state.Sidebar = &sidebar
+	timeline = filterTimeline(timeline, state.Filter, h.isBot)
 	state.Timeline = timeline
-	// Call loadTemplates to set updated reactionsBar, reactableID, etc., template functions.
-	t, err := loadTemplates(state.State, h.Options.BodyPre)
+	_, state.CanComment = h.cs.(commentCreator)
+	if wantsJSON(req) {
+		return writeJSON(w, newChangeDocument(state, h.EventRenderers))
+	}
+	t, err := h.timelineTemplates(req.Context(), &state, ts)
 	if err != nil {
-		return fmt.Errorf("loadTemplates: %v", err)
+		return err
+	}
+	if req.URL.Query().Get(timelineQueryKey) != "all" && len(timeline) > 2*timelineEdgeItems {
+		// Collapse the middle of a long timeline.
+		hidden := hiddenTimeline{From: timelineEdgeItems, To: len(timeline) - timelineEdgeItems}
+		query := state.Filter.Query()
+		query.Set("from", strconv.Itoa(hidden.From))
+		query.Set("to", strconv.Itoa(hidden.To))
+		hidden.URL = fmt.Sprintf("%s/%d/timeline?%s", state.BaseURI, state.ChangeID, query.Encode())
+		for _, item := range timeline[hidden.From:hidden.To] {
+			hidden.Anchors = append(hidden.Anchors, item.Anchors()...)
+		}
+		state.Timeline = timeline[:hidden.From]
+		state.HiddenTimeline = &hidden
+		state.TimelineTail = timeline[hidden.To:]
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err = t.ExecuteTemplate(w, "change.html.tmpl", &state)
@@ -393,6 +742,259 @@ func (h *handler) ChangeHandler(w http.ResponseWriter, req *http.Request, change
 	return nil
 }
 
+// timelineEdgeItems is the number of items displayed at the start and at the end
+// of a long timeline, whose middle is collapsed.
+const timelineEdgeItems = 10
+
+// FilterURL returns the URL of the change page with the current timeline filter,
+// except that the query key is set to value. An empty value removes the key.
+func (s state) FilterURL(key, value string) string {
+	query := s.Filter.Query()
+	if value == "" {
+		query.Del(key)
+	} else {
+		query.Set(key, value)
+	}
+	u := fmt.Sprintf("%s/%d", s.BaseURI, s.ChangeID)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// EventsURL returns the URL of server-sent events with new items of the displayed timeline.
+func (s state) EventsURL() string {
+	query := s.Filter.Query()
+	query.Set("after", strconv.Itoa(s.TimelineCount()))
+	return fmt.Sprintf("%s/%d/events?%s", s.BaseURI, s.ChangeID, query.Encode())
+}
+
+// TimelineCount returns the number of items in the entire timeline, including hidden ones.
+func (s state) TimelineCount() int {
+	n := len(s.Timeline) + len(s.TimelineTail)
+	if s.HiddenTimeline != nil {
+		n += s.HiddenTimeline.Count()
+	}
+	return n
+}
+
+// TimelineHandler is the handler for "/{changeID}/timeline" endpoint.
+// It renders an HTML fragment with timeline items in the [from, to) range,
+// where from and to are indices of the timeline sorted by creation time,
+// after it's filtered by the timeline filter in the URL query.
+// It's used to load items of a collapsed timeline.
+func (h *handler) TimelineHandler(w http.ResponseWriter, req *http.Request, changeID uint64) error {
+	if req.Method != http.MethodGet {
+		return httperror.Method{Allowed: []string{http.MethodGet}}
+	}
+	state, err := h.state(req, changeID)
+	if err != nil {
+		return err
+	}
+	// The change is needed to render the items the same way as on the change page,
+	// e.g., its author is needed for linking mentions.
+	state.Change, err = h.cs.Get(req.Context(), state.RepoSpec, state.ChangeID)
+	if err != nil {
+		return err
+	}
+	ts, err := h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
+	if err != nil {
+		return fmt.Errorf("changes.ListTimeline: %v", err)
+	}
+	filter, err := parseTimelineFilter(req.URL.Query())
+	if err != nil {
+		return httperror.BadRequest{Err: err}
+	}
+	var timeline []timelineItem
+	for _, item := range ts {
+		timeline = append(timeline, timelineItem{item})
+	}
+	sort.Sort(byCreatedAtID(timeline))
+	timeline = filterTimeline(timeline, filter, h.isBot)
+	from, err := strconv.Atoi(req.URL.Query().Get("from"))
+	if err != nil {
+		return httperror.BadRequest{Err: fmt.Errorf("invalid from: %v", err)}
+	}
+	to, err := strconv.Atoi(req.URL.Query().Get("to"))
+	if err != nil {
+		return httperror.BadRequest{Err: fmt.Errorf("invalid to: %v", err)}
+	}
+	if from < 0 || to < from || to > len(timeline) {
+		return httperror.BadRequest{Err: fmt.Errorf("range [%d, %d) is out of timeline bounds [0, %d)", from, to, len(timeline))}
+	}
+	t, err := h.timelineTemplates(req.Context(), &state, ts)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	for _, item := range timeline[from:to] {
+		err = t.ExecuteTemplate(w, "timeline-item", item)
+		if err != nil {
+			return fmt.Errorf("t.ExecuteTemplate: %v", err)
+		}
+	}
+	return nil
+}
+
+// timelineTemplates returns templates for rendering timeline ts of the change in state.
+// Parts of it that fail to load are recorded as failures in state.
+func (h *handler) timelineTemplates(ctx context.Context, state *state, ts []interface{}) (*template.Template, error) {
+	// Call loadTemplates to set updated reactionsBar, reactableID, etc., template functions.
+	t, err := loadTemplates(state.State, h.Options.BodyPre)
+	if err != nil {
+		return nil, fmt.Errorf("loadTemplates: %v", err)
+	}
+	l := h.changeLinker(ctx, state, ts)
+	t.Funcs(template.FuncMap{
+		"gfm":   func(s string) template.HTML { return gfm(l, s) },
+		"isBot": h.isBot,
+		"event": func(e change.TimelineItem) htmlg.Component {
+			return component.Event{Event: e, Renderers: h.EventRenderers}
+		},
+	})
+	if hasInlineComments(ts) {
+		d, err := h.diffContexts(ctx, state.RepoSpec, state.ChangeID)
+		if err != nil {
+			state.addFailure("code context of inline comments", err)
+		} else {
+			t.Funcs(template.FuncMap{"diffContext": d.Context})
+		}
+		threads, err := h.inlineThreads(ctx, state.RepoSpec, state.ChangeID, ts)
+		if err != nil {
+			state.addFailure("the state of inline comment threads", err)
+		}
+		state.UnresolvedThreads = unresolvedThreads(threads)
+		t.Funcs(template.FuncMap{"reviewThreads": func(r change.Review) []*inlineThread { return reviewThreads(threads, r) }})
+	}
+	return t, nil
+}
+
+// hasInlineComments reports whether any of the timeline items is a review with inline comments.
+func hasInlineComments(ts []interface{}) bool {
+	for _, item := range ts {
+		if r, ok := item.(change.Review); ok && len(r.Comments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// diffContexts returns code context for inline comments on the diff of the specified change.
+func (h *handler) diffContexts(ctx context.Context, repo string, id uint64) (*diffContexts, error) {
+	rawDiff, err := h.cs.GetDiff(ctx, repo, id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("changes.GetDiff: %v", err)
+	}
+	fileDiffs, err := diff.ParseMultiFileDiff(rawDiff)
+	if err != nil {
+		return nil, fmt.Errorf("diff.ParseMultiFileDiff: %v", err)
+	}
+	return &diffContexts{fileDiffs: fileDiffs}, nil
+}
+
+// commentCreator is implemented by change services that support creating comments.
+type commentCreator interface {
+	// CreateComment creates a comment on the specified change,
+	// authored by the currently authenticated user.
+	CreateComment(ctx context.Context, repo string, id uint64, c change.Comment) (change.Comment, error)
+}
+
+// CommentsHandler is the handler for "/{changeID}/comments" endpoint.
+// It creates a comment with the body submitted via a form, then redirects to it.
+func (h *handler) CommentsHandler(w http.ResponseWriter, req *http.Request, changeID uint64) error {
+	if req.Method != http.MethodPost {
+		return httperror.Method{Allowed: []string{http.MethodPost}}
+	}
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+	cc, ok := h.cs.(commentCreator)
+	if !ok {
+		return httperror.HTTP{Code: http.StatusNotFound, Err: errors.New("change service doesn't support creating comments")}
+	}
+	state, err := h.state(req, changeID)
+	if err != nil {
+		return err
+	}
+	if state.CurrentUser.ID == 0 {
+		return os.ErrPermission
+	}
+	body := req.PostFormValue("body")
+	if strings.TrimSpace(body) == "" {
+		return httperror.BadRequest{Err: errors.New("comment body must not be empty")}
+	}
+	comment, err := cc.CreateComment(req.Context(), state.RepoSpec, state.ChangeID, change.Comment{Body: body})
+	if err != nil {
+		return fmt.Errorf("CreateComment: %v", err)
+	}
+	return httperror.Redirect{URL: fmt.Sprintf("%s/%d#comment-%s", state.BaseURI, state.ChangeID, comment.ID)}
+}
+
+// reviewCreator is implemented by change services that support creating reviews.
+type reviewCreator interface {
+	// CreateReview creates a review of the specified change, including its inline comments,
+	// authored by the currently authenticated user.
+	CreateReview(ctx context.Context, repo string, id uint64, r change.Review) (change.Review, error)
+}
+
+// ReviewHandler is the handler for "/{changeID}/review" endpoint.
+// It creates a review with the vote, body and inline comments submitted via a form,
+// then redirects to it.
+//
+// The inline comments are a JSON array of objects with "file", "line" and "body" fields.
+func (h *handler) ReviewHandler(w http.ResponseWriter, req *http.Request, changeID uint64) error {
+	if req.Method != http.MethodPost {
+		return httperror.Method{Allowed: []string{http.MethodPost}}
+	}
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+	rc, ok := h.cs.(reviewCreator)
+	if !ok {
+		return httperror.HTTP{Code: http.StatusNotFound, Err: errors.New("change service doesn't support creating reviews")}
+	}
+	state, err := h.state(req, changeID)
+	if err != nil {
+		return err
+	}
+	if state.CurrentUser.ID == 0 {
+		return os.ErrPermission
+	}
+	review := change.Review{Body: req.PostFormValue("body")}
+	switch vote := req.PostFormValue("state"); vote {
+	case "+2", "2", "+1", "1", "0", "-1", "-2":
+		v, _ := strconv.Atoi(vote)
+		review.State = statepkg.Review(v)
+	default:
+		return httperror.BadRequest{Err: fmt.Errorf("unsupported review state %q (must be between -2 and +2)", vote)}
+	}
+	if v := req.PostFormValue("comments"); v != "" {
+		var comments []struct {
+			File string `json:"file"`
+			Line int    `json:"line"`
+			Body string `json:"body"`
+		}
+		err := json.Unmarshal([]byte(v), &comments)
+		if err != nil {
+			return httperror.BadRequest{Err: fmt.Errorf("parsing inline comments: %v", err)}
+		}
+		for _, c := range comments {
+			if c.File == "" || c.Line < 1 || strings.TrimSpace(c.Body) == "" {
+				return httperror.BadRequest{Err: fmt.Errorf("invalid inline comment on %s:%d", c.File, c.Line)}
+			}
+			review.Comments = append(review.Comments, change.InlineComment{File: c.File, Line: c.Line, Body: c.Body})
+		}
+	}
+	if review.State == statepkg.ReviewNoScore && strings.TrimSpace(review.Body) == "" && len(review.Comments) == 0 {
+		return httperror.BadRequest{Err: errors.New("review must have a vote, a body, or inline comments")}
+	}
+	review, err = rc.CreateReview(req.Context(), state.RepoSpec, state.ChangeID, review)
+	if err != nil {
+		return fmt.Errorf("CreateReview: %v", err)
+	}
+	return httperror.Redirect{URL: fmt.Sprintf("%s/%d#comment-%s", state.BaseURI, state.ChangeID, review.ID)}
+}
+
 func (s state) markRead(ctx context.Context, notificationService notifications.Service, changeService change.Service) error {
 	tt, ok := changeService.(interface {
 		ThreadType(repo string) string
@@ -428,6 +1030,21 @@ func (h *handler) ChangeCommitsHandler(w http.ResponseWriter, req *http.Request,
 	if err != nil {
 		return err
 	}
+	if wantsJSON(req) {
+		return writeJSON(w, newCommitsDocument(state, list))
+	}
+	if _, ok := h.cs.(threadService); ok {
+		// Fetch threads only to display the number of unresolved ones.
+		ts, err := h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
+		if err != nil {
+			state.addFailure("the number of unresolved threads", fmt.Errorf("changes.ListTimeline: %v", err))
+		}
+		threads, err := h.inlineThreads(req.Context(), state.RepoSpec, state.ChangeID, ts)
+		if err != nil {
+			state.addFailure("the number of unresolved threads", err)
+		}
+		state.UnresolvedThreads = unresolvedThreads(threads)
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err = h.static.ExecuteTemplate(w, "change-commits.html.tmpl", &state)
 	if err != nil {
@@ -459,7 +1076,10 @@ func (h *handler) ChangeFilesHandler(w http.ResponseWriter, req *http.Request, c
 	if err != nil {
 		return err
 	}
-	var commit commitMessage
+	var (
+		commit     commitMessage
+		thisCommit *change.Commit // Only set for single-commit view.
+	)
 	if commitID != "" {
 		cs, err := h.cs.ListCommits(req.Context(), state.RepoSpec, state.ChangeID)
 		if err != nil {
@@ -469,6 +1089,7 @@ func (h *handler) ChangeFilesHandler(w http.ResponseWriter, req *http.Request, c
 		if i == -1 {
 			return os.ErrNotExist
 		}
+		thisCommit = &cs[i]
 		subject, body := splitCommitMessage(cs[i].Message)
 		commit = commitMessage{
 			CommitHash: cs[i].SHA,
@@ -489,6 +1110,9 @@ func (h *handler) ChangeFilesHandler(w http.ResponseWriter, req *http.Request, c
 	if commitID != "" {
 		opt = &change.GetDiffOptions{Commit: commitID}
 	}
+	// Inline comments refer to lines of the entire change, so they can't be made on a single commit.
+	_, state.CanReview = h.cs.(reviewCreator)
+	state.CanReview = state.CanReview && commitID == ""
 	rawDiff, err := h.cs.GetDiff(req.Context(), state.RepoSpec, state.ChangeID, opt)
 	if err != nil {
 		return err
@@ -497,19 +1121,57 @@ func (h *handler) ChangeFilesHandler(w http.ResponseWriter, req *http.Request, c
 	if err != nil {
 		return err
 	}
+	if wantsJSON(req) {
+		return writeJSON(w, newFilesDocument(state, thisCommit, fileDiffs))
+	}
+	state.DiffView, err = diffView(w, req, state.BaseURI)
+	if err != nil {
+		return httperror.BadRequest{Err: err}
+	}
+	ts, err := h.cs.ListTimeline(req.Context(), state.RepoSpec, state.ChangeID, nil)
+	if err != nil {
+		state.addFailure("inline comments", fmt.Errorf("changes.ListTimeline: %v", err))
+	}
+	threads, err := h.inlineThreads(req.Context(), state.RepoSpec, state.ChangeID, ts)
+	if err != nil {
+		state.addFailure("the state of inline comment threads", err)
+	}
+	state.UnresolvedThreads = unresolvedThreads(threads)
+	var fileThreads map[string][]*inlineThread // File path -> threads on that file.
+	if commitID == "" {
+		// Inline comments refer to lines of the entire change, so they're only displayed on its diff.
+		fileThreads = threadsByFile(threads)
+	}
+	// Call loadTemplates to set updated reactionsBar, reactableID, etc., template functions.
+	t, err := loadTemplates(state.State, h.Options.BodyPre)
+	if err != nil {
+		return fmt.Errorf("loadTemplates: %v", err)
+	}
+	l := h.changeLinker(req.Context(), &state, ts)
+	t.Funcs(template.FuncMap{"gfm": func(s string) template.HTML { return gfm(l, s) }})
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err = h.static.ExecuteTemplate(w, "change-files.html.tmpl", &state)
+	err = t.ExecuteTemplate(w, "change-files.html.tmpl", &state)
 	if err != nil {
 		return err
 	}
 	if commitID != "" {
-		err = h.static.ExecuteTemplate(w, "CommitMessage", commit)
+		err = t.ExecuteTemplate(w, "CommitMessage", commit)
 		if err != nil {
 			return err
 		}
 	}
 	for _, f := range fileDiffs {
-		err = h.static.ExecuteTemplate(w, "FileDiff", fileDiff{FileDiff: f})
+		fd := fileDiff{FileDiff: f, Split: state.DiffView == "split"}
+		fd.Threads = fileThreads[fd.Path()]
+		delete(fileThreads, fd.Path())
+		err = t.ExecuteTemplate(w, "FileDiff", fd)
+		if err != nil {
+			return err
+		}
+	}
+	if len(fileThreads) > 0 {
+		// Threads on files that aren't in the diff, e.g., because they were removed or renamed.
+		err = t.ExecuteTemplate(w, "OutdatedFileThreads", sortedByFile(fileThreads))
 		if err != nil {
 			return err
 		}
@@ -547,19 +1209,7 @@ func (h *handler) state(req *http.Request, changeID uint64) (state, error) {
 	}
 	b.HeadPre = h.HeadPre
 	b.HeadPost = h.HeadPost
-	if h.BodyTop != nil {
-		c, err := h.BodyTop(req, b.State)
-		if err != nil {
-			return state{}, err
-		}
-		var buf bytes.Buffer
-		err = htmlg.RenderComponents(&buf, c...)
-		if err != nil {
-			return state{}, fmt.Errorf("htmlg.RenderComponents: %v", err)
-		}
-		b.BodyTop = template.HTML(buf.String())
-	}
-
+	b.SignInURL = h.SignInURL
 	b.DisableReactions = h.Options.DisableReactions
 	b.DisableUsers = h.us == nil
 
@@ -569,7 +1219,22 @@ func (h *handler) state(req *http.Request, changeID uint64) (state, error) {
 	} else if user, err := h.us.GetAuthenticated(req.Context()); err == nil {
 		b.CurrentUser = user
 	} else {
-		return state{}, fmt.Errorf("h.us.GetAuthenticated: %v", err)
+		// Continue as an unauthenticated user.
+		b.addFailure("the signed in user", fmt.Errorf("h.us.GetAuthenticated: %v", err))
+	}
+
+	if h.BodyTop != nil {
+		c, err := h.BodyTop(req, b.State)
+		if err != nil {
+			b.addFailure("the top of the page", fmt.Errorf("BodyTop: %v", err))
+		} else {
+			var buf bytes.Buffer
+			err = htmlg.RenderComponents(&buf, c...)
+			if err != nil {
+				return state{}, fmt.Errorf("htmlg.RenderComponents: %v", err)
+			}
+			b.BodyTop = template.HTML(buf.String())
+		}
 	}
 
 	return b, nil
@@ -581,9 +1246,67 @@ type state struct {
 
 	common.State
 
-	Changes  component.Changes
-	Change   change.Change
-	Timeline []timelineItem
+	Changes     component.Changes
+	LabelFacets component.LabelFacets
+	Change      change.Change
+	Timeline    []timelineItem
+	CanComment  bool // Whether the change service supports creating comments.
+	CanReview   bool // Whether the change service supports creating reviews, and the view allows it.
+	SignInURL   string
+
+	// HiddenTimeline is the collapsed middle of a long timeline, followed by TimelineTail.
+	// It's nil if the entire timeline is in Timeline.
+	HiddenTimeline *hiddenTimeline
+	TimelineTail   []timelineItem
+
+	ReviewSummary *component.ReviewSummary // Nil if nobody has voted.
+	Sidebar       *component.ChangeSidebar // Nil on pages other than Discussion.
+
+	DiffView string // How file diffs are displayed on the Files tab, "unified" or "split".
+
+	Filter  timelineFilter // Filter of the displayed timeline.
+	Authors []users.User   // Authors of all timeline items, for filtering by them.
+
+	// UnresolvedThreads is the number of unresolved threads of inline comments.
+	// It's only counted if the change service supports resolving threads.
+	UnresolvedThreads int
+
+	// failures are descriptions of optional parts of the page that failed to load.
+	failures []string
+}
+
+// addFailure records that an optional part of the page, described by what,
+// failed to load because of err. The page is still rendered, with a warning.
+func (s *state) addFailure(what string, err error) {
+	log.Printf("failed to load %s: %v", what, err)
+	failure := "Couldn't load " + what + "."
+	if s.CurrentUser.SiteAdmin {
+		failure += " " + err.Error()
+	}
+	s.failures = append(s.failures, failure)
+}
+
+// PartialFailure renders a warning about optional parts of the page that failed to load, if any.
+func (s state) PartialFailure() template.HTML {
+	return template.HTML(htmlg.RenderComponentsString(component.PartialFailure{Failures: s.failures}))
+}
+
+// FeedURL returns the URL of the Atom feed for the current page.
+// The feed of the changes list preserves the current state tab, search query and labels.
+func (s state) FeedURL() string {
+	if s.ChangeID != 0 {
+		return fmt.Sprintf("%s/%d/feed.atom", s.BaseURI, s.ChangeID)
+	}
+	q := make(url.Values)
+	for _, k := range []string{route.StateQueryKey, searchQueryKey, labelQueryKey} {
+		if v, ok := s.Changes.ChangesNav.Query[k]; ok {
+			q[k] = v
+		}
+	}
+	return (&url.URL{
+		Path:     s.BaseURI + "/feed.atom",
+		RawQuery: q.Encode(),
+	}).String()
 }
 
 // Tabnav renders the tabnav.
@@ -592,7 +1315,16 @@ func (s state) Tabnav(selected string) template.HTML {
 	if s.Change.ChangedFiles != 0 {
 		files = contentCounter{Content: files, Count: s.Change.ChangedFiles}
 	}
+	var extra htmlg.Component
+	if s.UnresolvedThreads > 0 {
+		text := fmt.Sprintf("%d unresolved threads", s.UnresolvedThreads)
+		if s.UnresolvedThreads == 1 {
+			text = "1 unresolved thread"
+		}
+		extra = iconText{Icon: octicon.CommentDiscussion, Text: text}
+	}
 	return template.HTML(htmlg.RenderComponentsString(tabnav{
+		Extra: extra,
 		Tabs: []tab{
 			{
 				Content: contentCounter{
@@ -630,7 +1362,7 @@ func loadTemplates(state common.State, bodyPre string) (*template.Template, erro
 			return string(b), err
 		},
 		"reltime":          humanize.Time,
-		"gfm":              func(s string) template.HTML { return template.HTML(github_flavored_markdown.Markdown([]byte(s))) },
+		"gfm":              func(s string) template.HTML { return gfm(&linker{st: state}, s) },
 		"reactionPosition": func(emojiID reactions.EmojiID) string { return reactions.Position(":" + string(emojiID) + ":") },
 		"equalUsers": func(a, b users.User) bool {
 			return a.UserSpec == b.UserSpec
@@ -673,6 +1405,20 @@ func loadTemplates(state common.State, bodyPre string) (*template.Template, erro
 		"time":             func(t time.Time) htmlg.Component { return component.Time{Time: t} },
 		"user":             func(u users.User) htmlg.Component { return component.User{User: u} },
 		"avatar":           func(u users.User) htmlg.Component { return component.Avatar{User: u, Size: 48} },
+		"smallAvatar":      func(u users.User) htmlg.Component { return component.Avatar{User: u, Size: 20} },
+
+		// isBot reports whether a user is a bot. It's overridden by handlers
+		// to use Options.IsBot.
+		"isBot": defaultIsBot,
+
+		// diffContext returns lines of the change's diff around an inline comment.
+		// It's overridden by handlers that have the diff.
+		"diffContext": func(file string, line int) ([]diffLine, error) { return nil, nil },
+		// reviewThreads returns threads started by inline comments of a review.
+		// It's overridden by handlers that have all reviews, so that threads can include replies from other reviews.
+		"reviewThreads": func(r change.Review) []*inlineThread {
+			return groupThreads(reviewComments(r), nil, nil, false)
+		},
 	})
 	t, err := vfstemplate.ParseGlob(assets.Assets, t, "/assets/*.tmpl")
 	if err != nil {
@@ -0,0 +1,67 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dmitri.shuralyov.com/service/change"
+)
+
+// commentsService is a change service with a single change,
+// whose timeline has the given number of comments.
+type commentsService struct {
+	change.Service
+	comments int
+}
+
+func (s commentsService) Get(_ context.Context, _ string, id uint64) (change.Change, error) {
+	return change.Change{ID: id, State: change.OpenState, Title: "Title"}, nil
+}
+
+func (s commentsService) ListTimeline(context.Context, string, uint64, *change.ListTimelineOptions) ([]interface{}, error) {
+	var ts []interface{}
+	for i := 0; i < s.comments; i++ {
+		ts = append(ts, change.Comment{
+			ID:        fmt.Sprint(i),
+			CreatedAt: time.Date(2018, 3, 1, 0, i, 0, 0, time.UTC),
+			Body:      "Comment.",
+		})
+	}
+	return ts, nil
+}
+
+// TestTimelineHandlerBounds tests that invalid ranges of timeline items are rejected.
+// Valid ranges aren't tested, since rendering them requires the templates in assets.
+func TestTimelineHandlerBounds(t *testing.T) {
+	h := &handler{cs: commentsService{comments: 3}}
+	eh := &errorHandler{handler: func(w http.ResponseWriter, req *http.Request) error {
+		ctx := context.WithValue(req.Context(), BaseURIContextKey, "/changes")
+		ctx = context.WithValue(ctx, RepoSpecContextKey, "repo")
+		return h.TimelineHandler(w, req.WithContext(ctx), 1)
+	}}
+	tests := []struct {
+		query    string
+		wantBody string // Part of the expected error message.
+	}{
+		{query: "from=-1&to=1", wantBody: "out of timeline bounds"},
+		{query: "from=0&to=4", wantBody: "out of timeline bounds"},
+		{query: "from=4&to=4", wantBody: "out of timeline bounds"},
+		{query: "from=2&to=1", wantBody: "out of timeline bounds"},
+		{query: "from=0&to=1&show=events", wantBody: "out of timeline bounds"}, // The filtered timeline is empty.
+		{query: "from=1", wantBody: "invalid to"},
+		{query: "from=a&to=1", wantBody: "invalid from"},
+		{query: "from=0&to=1&show=commits", wantBody: "unsupported show value"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		eh.ServeHTTP(w, httptest.NewRequest("GET", "/1/timeline?"+tc.query, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.wantBody) {
+			t.Errorf("TimelineHandler(%q): got status %d and body %q, want status %d and body containing %q", tc.query, w.Code, w.Body.String(), http.StatusBadRequest, tc.wantBody)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+
+	"dmitri.shuralyov.com/app/changes/common"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/github_flavored_markdown"
+	"github.com/shurcooL/htmlg"
+	"github.com/shurcooL/users"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// gfm renders the GitHub Flavored Markdown text s as sanitized HTML,
+// with references linked by l. It's used for rendering
+// all Markdown, including previews, so that they look the same.
+func gfm(l *linker, s string) template.HTML {
+	body := github_flavored_markdown.Markdown([]byte(s))
+	div := &html.Node{Type: html.ElementNode, Data: atom.Div.String(), DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(bytes.NewReader(body), div)
+	if err != nil {
+		log.Println("gfm: html.ParseFragment:", err)
+		return template.HTML(body)
+	}
+	htmlg.AppendChildren(div, nodes...)
+	l.linkify(div)
+	var buf bytes.Buffer
+	for n := div.FirstChild; n != nil; n = n.NextSibling {
+		err := html.Render(&buf, n)
+		if err != nil {
+			log.Println("gfm: html.Render:", err)
+			return template.HTML(body)
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+// linker turns references in rendered Markdown into links, in the context of the page described by st.
+// It's used by a single request, and caches what it looks up.
+type linker struct {
+	st    common.State
+	ctx   context.Context
+	cs    change.Service        // May be nil, then change references are linked without being looked up.
+	users map[string]users.User // Known users by login. Mentions of other users aren't linked.
+
+	changes map[uint64]*change.Change // Looked up changes by ID. The value is nil if the change doesn't exist.
+	commits map[string]bool           // SHAs of commits of the current change. Nil until looked up.
+}
+
+// linker returns a linker for Markdown on the page described by st.
+// Mentions of users in known are linked to their HTMLURL.
+func (h *handler) linker(ctx context.Context, st common.State, known []users.User) *linker {
+	l := &linker{
+		st:    st,
+		ctx:   ctx,
+		cs:    h.cs,
+		users: make(map[string]users.User),
+	}
+	for _, u := range known {
+		if u.Login != "" && u.HTMLURL != "" {
+			l.users[u.Login] = u
+		}
+	}
+	return l
+}
+
+// changeLinker returns a linker for Markdown on a page of the change in state with timeline ts.
+// Mentions of the change author, the current user and authors of timeline items are linked.
+func (h *handler) changeLinker(ctx context.Context, state *state, ts []interface{}) *linker {
+	var timeline []timelineItem
+	for _, item := range ts {
+		timeline = append(timeline, timelineItem{item})
+	}
+	known := append([]users.User{state.Change.Author, state.CurrentUser}, timelineAuthors(timeline)...)
+	return h.linker(ctx, state.State, known)
+}
+
+// referencePattern matches references that can be linked: "#1234", "CL 1234",
+// 40-character commit SHAs and "@login" mentions.
+var referencePattern = regexp.MustCompile(`\B#(\d+)\b|\bCL ?(\d+)\b|\b([0-9a-f]{40})\b|\B@([A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)\b`)
+
+// linkify replaces references in text nodes among descendants of n with links.
+// Text inside links and code isn't modified.
+func (l *linker) linkify(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling // c may be modified.
+		switch {
+		case c.Type == html.TextNode:
+			l.linkifyText(c)
+		case c.Type == html.ElementNode && (c.DataAtom == atom.A || c.DataAtom == atom.Code || c.DataAtom == atom.Pre):
+			// Skip.
+		default:
+			l.linkify(c)
+		}
+		c = next
+	}
+}
+
+// linkifyText replaces references in text node n with links.
+// Text before each link is inserted as a new text node,
+// and n keeps the text after the last link.
+func (l *linker) linkifyText(n *html.Node) {
+	text, last := n.Data, 0
+	for _, m := range referencePattern.FindAllStringSubmatchIndex(text, -1) {
+		a := l.link(text, m)
+		if a == nil {
+			continue
+		}
+		if m[0] > last {
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[last:m[0]]}, n)
+		}
+		n.Parent.InsertBefore(a, n)
+		last = m[1]
+	}
+	n.Data = text[last:]
+}
+
+// link returns a link for the reference in text at submatch indices m
+// of referencePattern, or nil if the reference can't be linked.
+func (l *linker) link(text string, m []int) *html.Node {
+	group := func(i int) string {
+		if m[2*i] == -1 {
+			return ""
+		}
+		return text[m[2*i]:m[2*i+1]]
+	}
+	switch {
+	case group(1) != "" || group(2) != "":
+		// Change reference.
+		id, err := strconv.ParseUint(group(1)+group(2), 10, 64)
+		if err != nil {
+			return nil
+		}
+		var title string
+		if l.cs != nil {
+			c := l.change(id)
+			if c == nil {
+				return nil
+			}
+			title = string(c.State)
+		}
+		return anchor(fmt.Sprintf("%s/%d", l.st.BaseURI, id), title, "change-reference", text[m[0]:m[1]])
+	case group(3) != "":
+		// Commit SHA.
+		sha := group(3)
+		if !l.hasCommit(sha) {
+			return nil
+		}
+		return anchor(fmt.Sprintf("%s/%d/files/%s", l.st.BaseURI, l.st.ChangeID, sha), sha, "commit-reference", sha[:8])
+	case group(4) != "":
+		// Mention.
+		u, ok := l.users[group(4)]
+		if !ok {
+			return nil
+		}
+		return anchor(u.HTMLURL, u.Name, "user-mention", "@"+u.Login)
+	default:
+		return nil
+	}
+}
+
+// change returns the change with the specified ID, or nil if it can't be looked up.
+func (l *linker) change(id uint64) *change.Change {
+	if c, ok := l.changes[id]; ok {
+		return c
+	}
+	if l.changes == nil {
+		l.changes = make(map[uint64]*change.Change)
+	}
+	c, err := l.cs.Get(l.ctx, l.st.RepoSpec, id)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("linker: changes.Get(%d): %v", id, err)
+		}
+		l.changes[id] = nil
+		return nil
+	}
+	l.changes[id] = &c
+	return &c
+}
+
+// hasCommit reports whether the commit with the specified SHA belongs to the current change.
+func (l *linker) hasCommit(sha string) bool {
+	if l.cs == nil || l.st.ChangeID == 0 {
+		return false
+	}
+	if l.commits == nil {
+		l.commits = make(map[string]bool)
+		cs, err := l.cs.ListCommits(l.ctx, l.st.RepoSpec, l.st.ChangeID)
+		if err != nil {
+			log.Println("linker: changes.ListCommits:", err)
+		}
+		for _, c := range cs {
+			l.commits[c.SHA] = true
+		}
+	}
+	return l.commits[sha]
+}
+
+// anchor returns a link to href with class and text, and an optional title.
+func anchor(href, title, class, text string) *html.Node {
+	a := &html.Node{
+		Type: html.ElementNode, Data: atom.A.String(), DataAtom: atom.A,
+		Attr: []html.Attribute{
+			{Key: atom.Href.String(), Val: href},
+			{Key: atom.Class.String(), Val: class},
+		},
+	}
+	a.AppendChild(htmlg.Text(text))
+	if title != "" {
+		a.Attr = append(a.Attr, html.Attribute{Key: atom.Title.String(), Val: title})
+	}
+	return a
+}
@@ -0,0 +1,113 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"dmitri.shuralyov.com/app/changes/common"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/htmlg"
+	"github.com/shurcooL/users"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// referenceService is a change service that only implements Get and ListCommits,
+// with changes and commit SHAs known ahead of time.
+type referenceService struct {
+	change.Service
+	changes map[uint64]change.Change
+	commits []string
+}
+
+func (s referenceService) Get(_ context.Context, _ string, id uint64) (change.Change, error) {
+	c, ok := s.changes[id]
+	if !ok {
+		return change.Change{}, os.ErrNotExist
+	}
+	return c, nil
+}
+
+func (s referenceService) ListCommits(context.Context, string, uint64) ([]change.Commit, error) {
+	var cs []change.Commit
+	for _, sha := range s.commits {
+		cs = append(cs, change.Commit{SHA: sha})
+	}
+	return cs, nil
+}
+
+func TestLinkify(t *testing.T) {
+	sha := strings.Repeat("0123456789", 4)
+	gopher := users.User{Login: "gopher", Name: "Gopher", HTMLURL: "https://example.com/gopher"}
+	tests := []struct {
+		name string
+		cs   change.Service
+		in   string
+		want string
+	}{
+		{
+			name: "change references without service",
+			in:   "Fixes #12, see CL 34 and CL56.",
+			want: `Fixes <a href="/changes/12" class="change-reference">#12</a>, see <a href="/changes/34" class="change-reference">CL 34</a> and <a href="/changes/56" class="change-reference">CL56</a>.`,
+		},
+		{
+			name: "change references with service",
+			cs:   referenceService{changes: map[uint64]change.Change{12: {ID: 12, State: change.MergedState}}},
+			in:   "#12 #13",
+			want: `<a href="/changes/12" class="change-reference" title="merged">#12</a> #13`,
+		},
+		{
+			name: "not references",
+			in:   "a#1 CL1x issue#2",
+			want: "a#1 CL1x issue#2",
+		},
+		{
+			name: "commit SHAs of the change",
+			cs:   referenceService{commits: []string{sha}},
+			in:   "Done in " + sha + ", not " + strings.Repeat("f", 40) + ".",
+			want: `Done in <a href="/changes/1/files/` + sha + `" class="commit-reference" title="` + sha + `">01234567</a>, not ` + strings.Repeat("f", 40) + ".",
+		},
+		{
+			name: "commit SHAs without service",
+			in:   sha,
+			want: sha,
+		},
+		{
+			name: "mentions of known users",
+			in:   "cc @gopher @nobody, mail gopher@example.com",
+			want: `cc <a href="https://example.com/gopher" class="user-mention" title="Gopher">@gopher</a> @nobody, mail gopher@example.com`,
+		},
+		{
+			name: "text in links and code",
+			in:   `<a href="/x">#1</a> <code>#2</code> <pre>@gopher</pre> <em>#3</em>`,
+			want: `<a href="/x">#1</a> <code>#2</code> <pre>@gopher</pre> <em><a href="/changes/3" class="change-reference">#3</a></em>`,
+		},
+	}
+	for _, tc := range tests {
+		l := &linker{
+			st:    common.State{BaseURI: "/changes", ChangeID: 1},
+			ctx:   context.Background(),
+			cs:    tc.cs,
+			users: map[string]users.User{gopher.Login: gopher},
+		}
+		div := &html.Node{Type: html.ElementNode, Data: atom.Div.String(), DataAtom: atom.Div}
+		nodes, err := html.ParseFragment(strings.NewReader(tc.in), div)
+		if err != nil {
+			t.Fatal(err)
+		}
+		htmlg.AppendChildren(div, nodes...)
+		l.linkify(div)
+		var buf bytes.Buffer
+		for n := div.FirstChild; n != nil; n = n.NextSibling {
+			if err := html.Render(&buf, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: linkify(%q):\ngot  %s\nwant %s", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
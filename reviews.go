@@ -0,0 +1,69 @@
+package changes
+
+import (
+	"sort"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/service/change"
+	statepkg "dmitri.shuralyov.com/state"
+	"github.com/shurcooL/users"
+)
+
+// DefaultApprovalRule is the approval rule used when Options.ApprovalRule is nil.
+// A change is approved if it has at least one +2 vote, and no −2 votes.
+func DefaultApprovalRule(votes []component.ReviewerVote) (approved bool, explanation string) {
+	var plus2, minus2 int
+	for _, v := range votes {
+		switch v.State {
+		case statepkg.ReviewPlus2:
+			plus2++
+		case statepkg.ReviewMinus2:
+			minus2++
+		}
+	}
+	switch {
+	case minus2 == 1:
+		return false, "Blocked by a −2 vote"
+	case minus2 > 1:
+		return false, "Blocked by −2 votes"
+	case plus2 == 0:
+		return false, "Needs a +2 vote"
+	default:
+		return true, "Approved"
+	}
+}
+
+// reviewSummary returns the summary of the latest votes of reviewers in timeline,
+// which must be sorted by creation time. It returns nil if nobody has voted.
+func (h *handler) reviewSummary(timeline []timelineItem) *component.ReviewSummary {
+	var (
+		votes []component.ReviewerVote
+		index = make(map[users.UserSpec]int) // Reviewer -> index of their vote in votes.
+	)
+	for _, item := range timeline {
+		r, ok := item.TimelineItem.(change.Review)
+		if !ok || r.State == statepkg.ReviewNoScore {
+			// Reviews without a vote don't change the previous vote.
+			continue
+		}
+		v := component.ReviewerVote{User: r.User, State: r.State, At: r.CreatedAt}
+		if i, ok := index[r.User.UserSpec]; ok {
+			votes[i] = v
+			continue
+		}
+		index[r.User.UserSpec] = len(votes)
+		votes = append(votes, v)
+	}
+	if len(votes) == 0 {
+		return nil
+	}
+	// Display the highest votes first, and the earliest ones among equal votes.
+	sort.SliceStable(votes, func(i, j int) bool { return votes[i].State > votes[j].State })
+
+	rule := h.ApprovalRule
+	if rule == nil {
+		rule = DefaultApprovalRule
+	}
+	approved, explanation := rule(votes)
+	return &component.ReviewSummary{Votes: votes, Approved: approved, Explanation: explanation}
+}
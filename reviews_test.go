@@ -0,0 +1,97 @@
+package changes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/service/change"
+	statepkg "dmitri.shuralyov.com/state"
+	"github.com/shurcooL/users"
+)
+
+func TestDefaultApprovalRule(t *testing.T) {
+	tests := []struct {
+		votes           []statepkg.Review
+		wantApproved    bool
+		wantExplanation string
+	}{
+		{votes: nil, wantApproved: false, wantExplanation: "Needs a +2 vote"},
+		{votes: []statepkg.Review{statepkg.ReviewPlus1, statepkg.ReviewPlus1}, wantApproved: false, wantExplanation: "Needs a +2 vote"},
+		{votes: []statepkg.Review{statepkg.ReviewPlus2}, wantApproved: true, wantExplanation: "Approved"},
+		{votes: []statepkg.Review{statepkg.ReviewPlus2, statepkg.ReviewMinus1}, wantApproved: true, wantExplanation: "Approved"},
+		{votes: []statepkg.Review{statepkg.ReviewPlus2, statepkg.ReviewMinus2}, wantApproved: false, wantExplanation: "Blocked by a −2 vote"},
+		{votes: []statepkg.Review{statepkg.ReviewMinus2, statepkg.ReviewMinus2}, wantApproved: false, wantExplanation: "Blocked by −2 votes"},
+	}
+	for _, tc := range tests {
+		var votes []component.ReviewerVote
+		for _, v := range tc.votes {
+			votes = append(votes, component.ReviewerVote{State: v})
+		}
+		approved, explanation := DefaultApprovalRule(votes)
+		if approved != tc.wantApproved || explanation != tc.wantExplanation {
+			t.Errorf("DefaultApprovalRule(%v): got %v, %q, want %v, %q", tc.votes, approved, explanation, tc.wantApproved, tc.wantExplanation)
+		}
+	}
+}
+
+func TestReviewSummary(t *testing.T) {
+	alice := users.User{UserSpec: users.UserSpec{ID: 1}, Login: "alice"}
+	bob := users.User{UserSpec: users.UserSpec{ID: 2}, Login: "bob"}
+	carol := users.User{UserSpec: users.UserSpec{ID: 3}, Login: "carol"}
+	at := func(minute int) time.Time { return time.Date(2018, 3, 1, 0, minute, 0, 0, time.UTC) }
+	review := func(u users.User, s statepkg.Review, minute int) timelineItem {
+		return timelineItem{change.Review{User: u, State: s, CreatedAt: at(minute)}}
+	}
+
+	tests := []struct {
+		name     string
+		timeline []timelineItem
+		rule     func([]component.ReviewerVote) (bool, string)
+		want     *component.ReviewSummary
+	}{
+		{
+			name:     "no votes",
+			timeline: []timelineItem{{change.Comment{User: alice}}, review(bob, statepkg.ReviewNoScore, 1)},
+			want:     nil,
+		},
+		{
+			name: "latest vote of each reviewer, highest first",
+			timeline: []timelineItem{
+				review(alice, statepkg.ReviewMinus2, 1),
+				review(bob, statepkg.ReviewPlus1, 2),
+				review(carol, statepkg.ReviewPlus1, 3),
+				review(alice, statepkg.ReviewPlus2, 4),
+				review(bob, statepkg.ReviewNoScore, 5), // Doesn't change bob's vote.
+			},
+			want: &component.ReviewSummary{
+				Votes: []component.ReviewerVote{
+					{User: alice, State: statepkg.ReviewPlus2, At: at(4)},
+					{User: bob, State: statepkg.ReviewPlus1, At: at(2)},
+					{User: carol, State: statepkg.ReviewPlus1, At: at(3)},
+				},
+				Approved:    true,
+				Explanation: "Approved",
+			},
+		},
+		{
+			name:     "custom rule",
+			timeline: []timelineItem{review(alice, statepkg.ReviewPlus1, 1)},
+			rule: func(votes []component.ReviewerVote) (bool, string) {
+				return len(votes) > 0, "Has votes"
+			},
+			want: &component.ReviewSummary{
+				Votes:       []component.ReviewerVote{{User: alice, State: statepkg.ReviewPlus1, At: at(1)}},
+				Approved:    true,
+				Explanation: "Has votes",
+			},
+		},
+	}
+	for _, tc := range tests {
+		h := &handler{Options: Options{ApprovalRule: tc.rule}}
+		if got := h.reviewSummary(tc.timeline); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: reviewSummary:\ngot  %+v\nwant %+v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Package route specifies the routing of the changes list page,
+// shared by the changes app and its components.
+package route
+
+import "fmt"
+
+// StateQueryKey is name of query key for controlling change state filter.
+const StateQueryKey = "state"
+
+// StateTab is a tab of the changes list page that shows changes in some state.
+type StateTab struct {
+	// Name is the value of StateQueryKey that selects this tab.
+	// The default tab has an empty name.
+	Name string
+
+	// Is is the "is:" search qualifier value that changes in this tab match,
+	// or empty string if the tab shows all changes.
+	Is string
+}
+
+// StateTabs are the state tabs of the changes list page, in display order.
+var StateTabs = []StateTab{
+	{Name: "", Is: "open"},
+	{Name: "closed", Is: "closed"},
+	{Name: "merged", Is: "merged"},
+	{Name: "all"},
+	{Name: "unread", Is: "unread"},
+}
+
+// StateTabByName returns the state tab with the given name,
+// returning an error if there isn't one.
+func StateTabByName(name string) (StateTab, error) {
+	for _, t := range StateTabs {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return StateTab{}, fmt.Errorf("unsupported state filter value: %q", name)
+}
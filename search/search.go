@@ -0,0 +1,326 @@
+// Package search implements a query language for searching changes.
+//
+// A query consists of space-separated terms. Each term is either a qualifier
+// of the form "operator:value", or a word that is matched against change titles.
+// Values that contain spaces can be quoted, e.g., label:"Needs Fix" or "two words".
+//
+// The supported qualifiers are:
+//
+// 	is:open, is:closed, is:merged  Change state. Merged changes are also considered closed.
+// 	is:unread                      Changes with unread notifications for the current user.
+// 	author:login                   Changes opened by the user with given login.
+// 	label:name                     Changes that have the given label.
+// 	reviewer:login                 Changes reviewed by, or awaiting a review from, the user with given login.
+// 	created:>2006-01-02            Changes created in the given range of dates.
+// 	                               The date can be preceded by one of >, >=, <, <=.
+//
+// All terms must match for a change to match a query.
+//
+// Most qualifiers are matched against the change alone, but reviewer: needs the timeline
+// of every change that matches the other terms, which takes a change service request
+// per change. It can be slow for repositories with many changes, so it's best combined
+// with other terms that narrow down the changes, such as is:open or author:.
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"dmitri.shuralyov.com/service/change"
+)
+
+// Query is a parsed search query.
+type Query struct {
+	Is        []string // Values of "is:" qualifiers, e.g., "open", "merged".
+	Authors   []string // Values of "author:" qualifiers.
+	Labels    []string // Values of "label:" qualifiers.
+	Reviewers []string // Values of "reviewer:" qualifiers.
+
+	// CreatedAfter and CreatedBefore bound the creation time of matching changes,
+	// as specified by "created:" qualifiers. A zero value means no bound.
+	CreatedAfter  time.Time // Inclusive.
+	CreatedBefore time.Time // Exclusive.
+
+	Words []string // Words and quoted phrases to match against change titles.
+}
+
+// Parse parses the search query s.
+// It returns an error if s isn't a valid query.
+func Parse(s string) (Query, error) {
+	terms, err := split(s)
+	if err != nil {
+		return Query{}, err
+	}
+	var q Query
+	for _, t := range terms {
+		if !t.qualifier {
+			q.Words = append(q.Words, t.value)
+			continue
+		}
+		if t.value == "" {
+			return Query{}, fmt.Errorf("missing value for %q qualifier", t.operator+":")
+		}
+		switch t.operator {
+		case "is":
+			switch t.value {
+			case "open", "closed", "merged", "unread":
+				q.Is = append(q.Is, t.value)
+			default:
+				return Query{}, fmt.Errorf("unsupported is: value %q (must be one of open, closed, merged, unread)", t.value)
+			}
+		case "author":
+			q.Authors = append(q.Authors, t.value)
+		case "label":
+			q.Labels = append(q.Labels, t.value)
+		case "reviewer":
+			q.Reviewers = append(q.Reviewers, t.value)
+		case "created":
+			after, before, err := parseDateRange(t.value)
+			if err != nil {
+				return Query{}, err
+			}
+			if !after.IsZero() && after.After(q.CreatedAfter) {
+				q.CreatedAfter = after
+			}
+			if !before.IsZero() && (q.CreatedBefore.IsZero() || before.Before(q.CreatedBefore)) {
+				q.CreatedBefore = before
+			}
+		default:
+			return Query{}, fmt.Errorf("unsupported qualifier %q", t.operator+":")
+		}
+	}
+	return q, nil
+}
+
+// term is a single term of a search query.
+type term struct {
+	qualifier bool
+	operator  string // Only set for qualifiers.
+	value     string // Unquoted value.
+}
+
+// split splits query s into terms.
+func split(s string) ([]term, error) {
+	var terms []term
+	for i := 0; ; {
+		// Skip whitespace.
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i == len(s) {
+			return terms, nil
+		}
+
+		// Read a term until unquoted whitespace.
+		var (
+			buf       strings.Builder
+			quoted    bool
+			quoteAt   int
+			operator  string
+			qualifier bool
+		)
+		for ; i < len(s) && (quoted || (s[i] != ' ' && s[i] != '\t')); i++ {
+			switch c := s[i]; {
+			case c == '"':
+				quoted = !quoted
+				quoteAt = i
+			case c == ':' && !quoted && !qualifier && isOperator(buf.String()):
+				operator, qualifier = buf.String(), true
+				buf.Reset()
+			default:
+				buf.WriteByte(c)
+			}
+		}
+		if quoted {
+			return nil, fmt.Errorf("unterminated quote at offset %d", quoteAt)
+		}
+		switch {
+		case qualifier && buf.Len() == 0 && !isKnownOperator(operator):
+			// A word that happens to end with a colon, such as "runtime:". Treat it as a word.
+			terms = append(terms, term{value: operator + ":"})
+		case qualifier:
+			terms = append(terms, term{qualifier: true, operator: operator, value: buf.String()})
+		case buf.Len() > 0:
+			terms = append(terms, term{value: buf.String()})
+		}
+	}
+}
+
+// isOperator reports whether s has the form of a qualifier operator.
+func isOperator(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLower(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isKnownOperator reports whether s is a supported qualifier operator.
+func isKnownOperator(s string) bool {
+	switch s {
+	case "is", "author", "label", "reviewer", "created":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseDateRange parses a date range value like ">=2006-01-02",
+// returning its inclusive lower and exclusive upper bounds.
+// A zero time means the range is unbounded on that side.
+func parseDateRange(s string) (after, before time.Time, err error) {
+	var op string
+	for _, prefix := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+	day, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported created: date %q (must be in YYYY-MM-DD format)", s)
+	}
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case ">":
+		return next, time.Time{}, nil
+	case ">=":
+		return day, time.Time{}, nil
+	case "<":
+		return time.Time{}, day, nil
+	case "<=":
+		return time.Time{}, next, nil
+	default:
+		return day, next, nil
+	}
+}
+
+// ListOptions returns the change.ListOptions for listing changes that may match q.
+//
+// complete reports whether the returned options fully capture q,
+// such that every listed change matches it. If not, listed changes
+// need to be checked with Match, MatchTimeline and MatchUnread.
+func (q Query) ListOptions() (opt change.ListOptions, complete bool) {
+	complete = len(q.Authors) == 0 && len(q.Labels) == 0 && len(q.Reviewers) == 0 &&
+		q.CreatedAfter.IsZero() && q.CreatedBefore.IsZero() && len(q.Words) == 0 && !q.Unread()
+	var states []string // Values of "is:" qualifiers that are change states.
+	for _, is := range q.Is {
+		if is == "unread" {
+			continue
+		}
+		states = append(states, is)
+	}
+	switch {
+	case len(states) == 0:
+		return change.ListOptions{Filter: change.FilterAll}, complete
+	case len(states) == 1 && states[0] == "open":
+		return change.ListOptions{Filter: change.FilterOpen}, complete
+	case len(states) == 1 && states[0] == "closed":
+		return change.ListOptions{Filter: change.FilterClosedMerged}, complete
+	case contains(states, "open"):
+		return change.ListOptions{Filter: change.FilterOpen}, false
+	default:
+		return change.ListOptions{Filter: change.FilterClosedMerged}, false
+	}
+}
+
+// Unread reports whether q only matches changes with unread notifications.
+func (q Query) Unread() bool {
+	return contains(q.Is, "unread")
+}
+
+// Match reports whether change c matches q.
+// Reviewer qualifiers aren't considered, since they require
+// the change timeline. Use MatchTimeline for those.
+// Neither is is:unread, use MatchUnread for that.
+func (q Query) Match(c change.Change) bool {
+	for _, is := range q.Is {
+		switch {
+		case is == "open" && c.State != change.OpenState,
+			is == "closed" && c.State != change.ClosedState && c.State != change.MergedState,
+			is == "merged" && c.State != change.MergedState:
+			return false
+		}
+	}
+	for _, author := range q.Authors {
+		if !strings.EqualFold(c.Author.Login, author) {
+			return false
+		}
+	}
+	for _, label := range q.Labels {
+		if !hasLabel(c, label) {
+			return false
+		}
+	}
+	if !q.CreatedAfter.IsZero() && c.CreatedAt.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !c.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	title := strings.ToLower(c.Title)
+	for _, word := range q.Words {
+		if !strings.Contains(title, strings.ToLower(word)) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTimeline reports whether a change with the given timeline
+// matches the reviewer qualifiers of q. A reviewer matches if they
+// reviewed the change, or if a review was requested from them.
+func (q Query) MatchTimeline(timeline []interface{}) bool {
+	for _, reviewer := range q.Reviewers {
+		if !hasReviewer(timeline, reviewer) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchUnread reports whether a change matches the is:unread qualifier of q.
+// unread indicates whether the change has unread notifications for the current user.
+func (q Query) MatchUnread(unread bool) bool {
+	return unread || !q.Unread()
+}
+
+func hasLabel(c change.Change, name string) bool {
+	for _, l := range c.Labels {
+		if strings.EqualFold(l.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasReviewer(timeline []interface{}, login string) bool {
+	for _, item := range timeline {
+		switch item := item.(type) {
+		case change.Review:
+			if strings.EqualFold(item.User.Login, login) {
+				return true
+			}
+		case change.TimelineItem:
+			if p, ok := item.Payload.(change.ReviewRequestedEvent); ok && strings.EqualFold(p.RequestedReviewer.Login, login) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
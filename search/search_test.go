@@ -0,0 +1,268 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/issues"
+	"github.com/shurcooL/users"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Query
+		wantErr bool
+	}{
+		{in: "", want: Query{}},
+		{in: "   ", want: Query{}},
+		{in: "is:open", want: Query{Is: []string{"open"}}},
+		{in: "is:open author:gopher", want: Query{Is: []string{"open"}, Authors: []string{"gopher"}}},
+		{in: `label:"Needs Fix" label:bug`, want: Query{Labels: []string{"Needs Fix", "bug"}}},
+		{in: "reviewer:alice", want: Query{Reviewers: []string{"alice"}}},
+		{in: `fix "data race" runtime:`, want: Query{Words: []string{"fix", "data race", "runtime:"}}},
+		{in: "Author:gopher", want: Query{Words: []string{"Author:gopher"}}},
+		{in: "created:2018-03-01", want: Query{CreatedAfter: date("2018-03-01"), CreatedBefore: date("2018-03-02")}},
+		{
+			in:   "created:>=2018-01-01 created:>2018-02-01 created:<2018-06-01 created:<=2018-04-01",
+			want: Query{CreatedAfter: date("2018-02-02"), CreatedBefore: date("2018-04-02")},
+		},
+		{in: "is:", wantErr: true},
+		{in: "author:", wantErr: true},
+		{in: "is:draft", wantErr: true},
+		{in: "created:yesterday", wantErr: true},
+		{in: "milestone:go1.11", wantErr: true},
+		{in: `label:"Needs Fix`, wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := Parse(tc.in)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("Parse(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q):\ngot  %+v\nwant %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []term
+	}{
+		{in: "", want: nil},
+		{in: " \t ", want: nil},
+		{in: "a  b", want: []term{{value: "a"}, {value: "b"}}},
+		{in: `"a b"`, want: []term{{value: "a b"}}},
+		{in: `a"b c"d`, want: []term{{value: "ab cd"}}},
+		{in: `""`, want: nil},
+		{in: "is:open", want: []term{{qualifier: true, operator: "is", value: "open"}}},
+		{in: `label:"a:b"`, want: []term{{qualifier: true, operator: "label", value: "a:b"}}},
+		{in: "is:", want: []term{{qualifier: true, operator: "is"}}},
+		{in: "foo:", want: []term{{value: "foo:"}}},
+		{in: "foo:bar", want: []term{{qualifier: true, operator: "foo", value: "bar"}}},
+		{in: ":x", want: []term{{value: ":x"}}},
+	}
+	for _, tc := range tests {
+		got, err := split(tc.in)
+		if err != nil {
+			t.Errorf("split(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("split(%q):\ngot  %+v\nwant %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		in                    string
+		wantAfter, wantBefore time.Time
+		wantErr               bool
+	}{
+		{in: "2018-03-01", wantAfter: date("2018-03-01"), wantBefore: date("2018-03-02")},
+		{in: ">2018-03-01", wantAfter: date("2018-03-02")},
+		{in: ">=2018-03-01", wantAfter: date("2018-03-01")},
+		{in: "<2018-03-01", wantBefore: date("2018-03-01")},
+		{in: "<=2018-03-01", wantBefore: date("2018-03-02")},
+		{in: "<=2018-12-31", wantBefore: date("2019-01-01")},
+		{in: "=2018-03-01", wantErr: true},
+		{in: "2018-3-1", wantErr: true},
+		{in: ">", wantErr: true},
+	}
+	for _, tc := range tests {
+		after, before, err := parseDateRange(tc.in)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseDateRange(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !after.Equal(tc.wantAfter) || !before.Equal(tc.wantBefore) {
+			t.Errorf("parseDateRange(%q): got [%v, %v), want [%v, %v)", tc.in, after, before, tc.wantAfter, tc.wantBefore)
+		}
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	tests := []struct {
+		in           string
+		want         change.StateFilter
+		wantComplete bool
+	}{
+		{in: "", want: change.FilterAll, wantComplete: true},
+		{in: "is:open", want: change.FilterOpen, wantComplete: true},
+		{in: "is:closed", want: change.FilterClosedMerged, wantComplete: true},
+		{in: "is:open author:gopher", want: change.FilterOpen, wantComplete: false},
+		{in: "is:closed fix", want: change.FilterClosedMerged, wantComplete: false},
+		{in: "created:>2018-01-01", want: change.FilterAll, wantComplete: false},
+		{in: "reviewer:alice", want: change.FilterAll, wantComplete: false},
+		{in: "is:merged", want: change.FilterClosedMerged, wantComplete: false},
+		{in: "is:closed is:merged", want: change.FilterClosedMerged, wantComplete: false},
+		{in: "is:open is:merged", want: change.FilterOpen, wantComplete: false},
+		{in: "is:unread", want: change.FilterAll, wantComplete: false},
+		{in: "is:open is:unread", want: change.FilterOpen, wantComplete: false},
+	}
+	for _, tc := range tests {
+		q, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		opt, complete := q.ListOptions()
+		if opt.Filter != tc.want || complete != tc.wantComplete {
+			t.Errorf("Parse(%q).ListOptions(): got %q, %v, want %q, %v", tc.in, opt.Filter, complete, tc.want, tc.wantComplete)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	c := change.Change{
+		State:     change.OpenState,
+		Title:     "net/http: fix data race in Transport",
+		Labels:    []issues.Label{{Name: "NeedsFix"}},
+		Author:    users.User{Login: "Gopher"},
+		CreatedAt: date("2018-03-01").Add(12 * time.Hour),
+	}
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{in: "", want: true},
+		{in: "is:open", want: true},
+		{in: "is:closed", want: false},
+		{in: "is:merged", want: false},
+		{in: "author:gopher", want: true},
+		{in: "author:alice", want: false},
+		{in: "label:needsfix", want: true},
+		{in: "label:NeedsFix label:bug", want: false},
+		{in: "DATA race", want: true},
+		{in: `"race in transport"`, want: true},
+		{in: "race client", want: false},
+		{in: "created:2018-03-01", want: true},
+		{in: "created:>2018-03-01", want: false},
+		{in: "created:<2018-03-01", want: false},
+		{in: "created:<=2018-03-01", want: true},
+		{in: "reviewer:alice", want: true}, // Reviewers are matched by MatchTimeline.
+	}
+	for _, tc := range tests {
+		q, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		if got := q.Match(c); got != tc.want {
+			t.Errorf("Parse(%q).Match: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMatchState(t *testing.T) {
+	tests := []struct {
+		in                   string
+		open, closed, merged bool
+	}{
+		{in: "", open: true, closed: true, merged: true},
+		{in: "is:open", open: true},
+		{in: "is:closed", closed: true, merged: true},
+		{in: "is:merged", merged: true},
+		{in: "is:closed is:merged", merged: true},
+		{in: "is:open is:merged"},
+	}
+	for _, tc := range tests {
+		q, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		for state, want := range map[change.State]bool{
+			change.OpenState:   tc.open,
+			change.ClosedState: tc.closed,
+			change.MergedState: tc.merged,
+		} {
+			if got := q.Match(change.Change{State: state}); got != want {
+				t.Errorf("Parse(%q).Match of %s change: got %v, want %v", tc.in, state, got, want)
+			}
+		}
+	}
+}
+
+func TestMatchTimeline(t *testing.T) {
+	timeline := []interface{}{
+		change.Comment{User: users.User{Login: "carol"}},
+		change.Review{User: users.User{Login: "Alice"}},
+		change.TimelineItem{Payload: change.ReviewRequestedEvent{RequestedReviewer: users.User{Login: "bob"}}},
+		change.TimelineItem{Actor: users.User{Login: "dave"}, Payload: change.LabeledEvent{}},
+	}
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{in: "", want: true},
+		{in: "reviewer:alice", want: true},
+		{in: "reviewer:bob", want: true},
+		{in: "reviewer:alice reviewer:bob", want: true},
+		{in: "reviewer:carol", want: false},
+		{in: "reviewer:dave", want: false},
+		{in: "reviewer:alice reviewer:eve", want: false},
+	}
+	for _, tc := range tests {
+		q, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		if got := q.MatchTimeline(timeline); got != tc.want {
+			t.Errorf("Parse(%q).MatchTimeline: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMatchUnread(t *testing.T) {
+	tests := []struct {
+		in     string
+		unread bool
+		want   bool
+	}{
+		{in: "", unread: false, want: true},
+		{in: "", unread: true, want: true},
+		{in: "is:unread", unread: false, want: false},
+		{in: "is:unread", unread: true, want: true},
+		{in: "is:open is:unread", unread: true, want: true},
+	}
+	for _, tc := range tests {
+		q, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		if got := q.MatchUnread(tc.unread); got != tc.want {
+			t.Errorf("Parse(%q).MatchUnread(%v): got %v, want %v", tc.in, tc.unread, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+
+	"dmitri.shuralyov.com/app/changes/component"
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/users"
+)
+
+// refsGetter is implemented by change services that know
+// the base and head refs of changes.
+type refsGetter interface {
+	// GetRefs returns the names of the base and head refs of the specified change.
+	GetRefs(ctx context.Context, repo string, id uint64) (base, head string, _ error)
+}
+
+// changeSidebar returns the sidebar of the change in state, with metadata
+// derived from its entire timeline, which must be sorted by creation time.
+func (h *handler) changeSidebar(ctx context.Context, state *state, timeline []timelineItem) component.ChangeSidebar {
+	s := component.ChangeSidebar{
+		Change:       state.Change,
+		Reviewers:    requestedReviewers(timeline),
+		Participants: append([]users.User{state.Change.Author}, state.Authors...),
+
+		ChangeURL:      fmt.Sprintf("%s/%d", state.BaseURI, state.ChangeID),
+		ListURL:        state.BaseURI,
+		SearchQueryKey: searchQueryKey,
+		LabelQueryKey:  labelQueryKey,
+	}
+	s.Participants = uniqueUsers(s.Participants)
+
+	// Refs are known from merge and branch deletion events,
+	// unless the change service can provide them directly.
+	for _, item := range timeline {
+		e, ok := item.TimelineItem.(change.TimelineItem)
+		if !ok {
+			continue
+		}
+		switch p := e.Payload.(type) {
+		case change.MergedEvent:
+			s.BaseRef = p.RefName
+		case change.DeletedEvent:
+			if p.Type == "branch" {
+				s.HeadRef = p.Name
+			}
+		}
+	}
+	if rg, ok := h.cs.(refsGetter); ok {
+		base, head, err := rg.GetRefs(ctx, state.RepoSpec, state.ChangeID)
+		if err != nil {
+			state.addFailure("the refs", fmt.Errorf("GetRefs: %v", err))
+		} else {
+			s.BaseRef, s.HeadRef = base, head
+		}
+	}
+	return s
+}
+
+// requestedReviewers returns the users whose review is requested in timeline,
+// in order of their requests. Requests that were later removed are skipped.
+func requestedReviewers(timeline []timelineItem) []users.User {
+	var reviewers []users.User
+	for _, item := range timeline {
+		e, ok := item.TimelineItem.(change.TimelineItem)
+		if !ok {
+			continue
+		}
+		switch p := e.Payload.(type) {
+		case change.ReviewRequestedEvent:
+			reviewers = uniqueUsers(append(reviewers, p.RequestedReviewer))
+		case change.ReviewRequestRemovedEvent:
+			for i, u := range reviewers {
+				if u.UserSpec == p.RequestedReviewer.UserSpec {
+					reviewers = append(reviewers[:i], reviewers[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return reviewers
+}
+
+// uniqueUsers returns us without repeated users, keeping the first occurrence of each.
+func uniqueUsers(us []users.User) []users.User {
+	var (
+		unique []users.User
+		seen   = make(map[users.UserSpec]bool)
+	)
+	for _, u := range us {
+		if !seen[u.UserSpec] {
+			seen[u.UserSpec] = true
+			unique = append(unique, u)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,73 @@
+package changes
+
+import (
+	"reflect"
+	"testing"
+
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/users"
+)
+
+func TestRequestedReviewers(t *testing.T) {
+	alice := users.User{UserSpec: users.UserSpec{ID: 1}, Login: "alice"}
+	bob := users.User{UserSpec: users.UserSpec{ID: 2}, Login: "bob"}
+	requested := func(u users.User) timelineItem {
+		return timelineItem{change.TimelineItem{Payload: change.ReviewRequestedEvent{RequestedReviewer: u}}}
+	}
+	removed := func(u users.User) timelineItem {
+		return timelineItem{change.TimelineItem{Payload: change.ReviewRequestRemovedEvent{RequestedReviewer: u}}}
+	}
+	tests := []struct {
+		name     string
+		timeline []timelineItem
+		want     []string
+	}{
+		{
+			name:     "none",
+			timeline: []timelineItem{{change.Comment{User: alice}}, {change.Review{User: bob}}},
+			want:     nil,
+		},
+		{
+			name:     "in order of requests, without repeats",
+			timeline: []timelineItem{requested(bob), requested(alice), requested(bob)},
+			want:     []string{"bob", "alice"},
+		},
+		{
+			name:     "removed",
+			timeline: []timelineItem{requested(alice), requested(bob), removed(alice)},
+			want:     []string{"bob"},
+		},
+		{
+			name:     "requested, removed, then requested again",
+			timeline: []timelineItem{requested(alice), requested(bob), removed(alice), requested(alice)},
+			want:     []string{"bob", "alice"},
+		},
+		{
+			name:     "removed without a request",
+			timeline: []timelineItem{removed(alice), requested(bob)},
+			want:     []string{"bob"},
+		},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, u := range requestedReviewers(tc.timeline) {
+			got = append(got, u.Login)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: requestedReviewers: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestUniqueUsers(t *testing.T) {
+	alice := users.User{UserSpec: users.UserSpec{ID: 1}, Login: "alice"}
+	bob := users.User{UserSpec: users.UserSpec{ID: 2}, Login: "bob"}
+	bobRenamed := users.User{UserSpec: users.UserSpec{ID: 2}, Login: "robert"}
+	got := uniqueUsers([]users.User{alice, bob, alice, bobRenamed})
+	if want := []users.User{alice, bob}; !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueUsers: got %v, want %v", got, want)
+	}
+	if got := uniqueUsers(nil); got != nil {
+		t.Errorf("uniqueUsers(nil): got %v, want nil", got)
+	}
+}
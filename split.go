@@ -0,0 +1,110 @@
+package changes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// diffViewCookie is the name of the cookie that remembers the diff view
+// last selected via diffQueryKey, so it doesn't need to be selected again.
+const diffViewCookie = "diffView"
+
+// diffView returns the diff view selected by req, either "unified" or "split".
+// A view selected via the URL query is remembered in a cookie set on w,
+// and the view in that cookie is used when the query doesn't select one.
+func diffView(w http.ResponseWriter, req *http.Request, baseURI string) (string, error) {
+	switch view := req.URL.Query().Get(diffQueryKey); view {
+	case "unified", "split":
+		path := baseURI
+		if path == "" {
+			path = "/"
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     diffViewCookie,
+			Value:    view,
+			Path:     path,
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+		})
+		return view, nil
+	case "":
+		if c, err := req.Cookie(diffViewCookie); err == nil && c.Value == "split" {
+			return "split", nil
+		}
+		return "unified", nil
+	default:
+		return "", fmt.Errorf("unsupported %s value %q", diffQueryKey, view)
+	}
+}
+
+// DiffViewURL returns the URL of the current page with the diff view set to view.
+func (s state) DiffViewURL(view string) string {
+	return s.BaseURI + s.ReqPath + "?" + url.Values{diffQueryKey: {view}}.Encode()
+}
+
+// splitRow is a row of a split diff, with a line of the old file on the left
+// and a line of the new file on the right, for display purposes.
+type splitRow struct {
+	Hunk        *diffLine // Hunk header that spans both sides, or nil if the row has lines.
+	Left, Right *diffLine // Lines on each side, or nil if that side is empty.
+}
+
+// SplitRows returns the rows of the file diff laid out side by side.
+// Context lines are on both sides. Each block of deleted lines is aligned
+// with the block of added lines that follows it, so that changed lines
+// are next to each other.
+func (f fileDiff) SplitRows() ([]splitRow, error) {
+	lines, err := f.Lines()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rows           []splitRow
+		deleted, added []*diffLine // Current block of changed lines.
+	)
+	flush := func() {
+		for i := 0; i < len(deleted) || i < len(added); i++ {
+			var r splitRow
+			if i < len(deleted) {
+				r.Left = deleted[i]
+			}
+			if i < len(added) {
+				r.Right = added[i]
+			}
+			rows = append(rows, r)
+		}
+		deleted, added = nil, nil
+	}
+	for i := range lines {
+		l := &lines[i]
+		switch l.Type {
+		case "deleted":
+			if len(added) > 0 {
+				// Deleted lines after added lines start a new block.
+				flush()
+			}
+			deleted = append(deleted, l)
+		case "added":
+			added = append(added, l)
+		case "nonewline":
+			// It applies to the line above, so it goes on the same side.
+			switch {
+			case len(added) > 0:
+				added = append(added, l)
+			case len(deleted) > 0:
+				deleted = append(deleted, l)
+			default:
+				rows = append(rows, splitRow{Left: l, Right: l})
+			}
+		case "hunk":
+			flush()
+			rows = append(rows, splitRow{Hunk: l})
+		default:
+			flush()
+			rows = append(rows, splitRow{Left: l, Right: l})
+		}
+	}
+	flush()
+	return rows, nil
+}
@@ -0,0 +1,110 @@
+package changes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSplitRows(t *testing.T) {
+	f := parseFileDiff(t, `--- a/f.go
++++ b/f.go
+@@ -1,6 +1,6 @@
+ a
+-b
+-c
++B
+ d
+-e
++E
++F
+-g
+\ No newline at end of file
++G
+\ No newline at end of file
+`)
+	rows, err := f.SplitRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// side describes a line on one side of a row.
+	side := func(l *diffLine) string {
+		switch {
+		case l == nil:
+			return ""
+		case l.Type == "nonewline":
+			return `\`
+		case l.Type == "deleted":
+			return fmt.Sprintf("-%d", l.OldLine)
+		case l.Type == "added":
+			return fmt.Sprintf("+%d", l.NewLine)
+		default:
+			return fmt.Sprintf("%d,%d", l.OldLine, l.NewLine)
+		}
+	}
+	var got [][2]string
+	for _, r := range rows {
+		if r.Hunk != nil {
+			got = append(got, [2]string{"@@", "@@"})
+			continue
+		}
+		got = append(got, [2]string{side(r.Left), side(r.Right)})
+	}
+	want := [][2]string{
+		{"@@", "@@"},
+		{"1,1", "1,1"},
+		{"-2", "+2"},
+		{"-3", ""},
+		{"4,3", "4,3"},
+		{"-5", "+4"},
+		{"", "+5"},
+		{"-6", "+6"},
+		{`\`, `\`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitRows:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestDiffView(t *testing.T) {
+	tests := []struct {
+		query      string
+		cookie     string
+		want       string
+		wantCookie string
+		wantErr    bool
+	}{
+		{query: "", want: "unified"},
+		{query: "", cookie: "split", want: "split"},
+		{query: "", cookie: "bogus", want: "unified"},
+		{query: "diff=split", want: "split", wantCookie: "split"},
+		{query: "diff=unified", cookie: "split", want: "unified", wantCookie: "unified"},
+		{query: "diff=stacked", wantErr: true},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/changes/1/files?"+tc.query, nil)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: diffViewCookie, Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		got, err := diffView(w, req, "/changes")
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("diffView(%q, cookie %q): got error %v, want error: %v", tc.query, tc.cookie, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("diffView(%q, cookie %q): got %q, want %q", tc.query, tc.cookie, got, tc.want)
+		}
+		var gotCookie string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == diffViewCookie {
+				gotCookie = c.Value
+			}
+		}
+		if gotCookie != tc.wantCookie {
+			t.Errorf("diffView(%q, cookie %q): got cookie %q, want %q", tc.query, tc.cookie, gotCookie, tc.wantCookie)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"dmitri.shuralyov.com/service/change"
+	"github.com/shurcooL/httperror"
+)
+
+// threadService is implemented by change services that support replying to
+// inline comments, and resolving threads of them.
+type threadService interface {
+	// ListThreadInfo returns the reply relationships and resolved state of inline comments
+	// of the specified change. replyTo maps the ID of each inline comment that is a reply
+	// to the ID of the comment it replies to. resolved contains the IDs of the first comments
+	// of resolved threads.
+	ListThreadInfo(ctx context.Context, repo string, id uint64) (replyTo map[string]string, resolved map[string]bool, err error)
+
+	// ReplyToInlineComment creates an inline comment that replies to inline comment commentID
+	// of the specified change, authored by the currently authenticated user.
+	ReplyToInlineComment(ctx context.Context, repo string, id uint64, commentID, body string) (change.InlineComment, error)
+
+	// SetThreadResolved resolves or unresolves the thread started by inline comment commentID
+	// of the specified change.
+	SetThreadResolved(ctx context.Context, repo string, id uint64, commentID string, resolved bool) error
+}
+
+// inlineThread is a thread of inline comments on a line of a file, for display purposes.
+type inlineThread struct {
+	Comments   []reviewComment // Sorted by creation time. The first comment starts the thread.
+	Resolved   bool
+	Resolvable bool // Whether the change service supports replying to and resolving the thread.
+}
+
+// ID returns the ID of the thread, which is the ID of its first comment.
+func (t *inlineThread) ID() string { return t.Comments[0].ID }
+
+// File returns the path of the commented file.
+func (t *inlineThread) File() string { return t.Comments[0].File }
+
+// Line returns the commented line of the new file.
+func (t *inlineThread) Line() int { return t.Comments[0].Line }
+
+// inlineThreads groups inline comments of all reviews in timeline ts into threads,
+// sorted by creation time. If the change service supports threads, comments are grouped
+// by their reply relationships. Otherwise, all comments on the same line are one thread.
+//
+// If the reply relationships can't be fetched, the comments are still grouped by line
+// and returned along with a non-nil error.
+func (h *handler) inlineThreads(ctx context.Context, repo string, id uint64, ts []interface{}) ([]*inlineThread, error) {
+	var comments []reviewComment
+	for _, item := range ts {
+		if r, ok := item.(change.Review); ok {
+			comments = append(comments, reviewComments(r)...)
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].CreatedAt.Before(comments[j].CreatedAt) })
+
+	ths, ok := h.cs.(threadService)
+	if !ok {
+		return groupThreads(comments, nil, nil, false), nil
+	}
+	if len(comments) == 0 {
+		return nil, nil
+	}
+	replyTo, resolved, err := ths.ListThreadInfo(ctx, repo, id)
+	if err != nil {
+		return groupThreads(comments, nil, nil, false), fmt.Errorf("ListThreadInfo: %v", err)
+	}
+	return groupThreads(comments, replyTo, resolved, true), nil
+}
+
+// reviewComments returns inline comments of review r.
+func reviewComments(r change.Review) []reviewComment {
+	var cs []reviewComment
+	for _, c := range r.Comments {
+		cs = append(cs, reviewComment{
+			InlineComment: c,
+			ReviewID:      r.ID,
+			User:          r.User,
+			CreatedAt:     r.CreatedAt,
+		})
+	}
+	return cs
+}
+
+// groupThreads groups comments, which must be sorted by creation time, into threads.
+// If replyTo is nil, comments on the same line of the same file are grouped together.
+func groupThreads(comments []reviewComment, replyTo map[string]string, resolved map[string]bool, resolvable bool) []*inlineThread {
+	exists := make(map[string]bool)
+	for _, c := range comments {
+		exists[c.ID] = true
+	}
+	var (
+		threads []*inlineThread
+		byKey   = make(map[string]*inlineThread) // Thread key -> thread.
+	)
+	for _, c := range comments {
+		var key string
+		if replyTo == nil {
+			key = fmt.Sprintf("%s:%d", c.File, c.Line)
+		} else {
+			// Find the first comment of the thread. Replies to comments that
+			// don't exist start new threads, and the number of steps is bounded
+			// in case of a cycle.
+			key = c.ID
+			for i := 0; i < len(comments); i++ {
+				parent, ok := replyTo[key]
+				if !ok || !exists[parent] {
+					break
+				}
+				key = parent
+			}
+		}
+		t, ok := byKey[key]
+		if !ok {
+			t = &inlineThread{Resolved: resolved[c.ID], Resolvable: resolvable}
+			byKey[key] = t
+			threads = append(threads, t)
+		}
+		t.Comments = append(t.Comments, c)
+	}
+	return threads
+}
+
+// threadsByFile returns threads grouped by the path of the commented file.
+func threadsByFile(threads []*inlineThread) map[string][]*inlineThread {
+	m := make(map[string][]*inlineThread)
+	for _, t := range threads {
+		m[t.File()] = append(m[t.File()], t)
+	}
+	return m
+}
+
+// sortedByFile returns the threads in fileThreads sorted by file path,
+// keeping the order of threads on the same file.
+func sortedByFile(fileThreads map[string][]*inlineThread) []*inlineThread {
+	var files []string
+	for file := range fileThreads {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	var ts []*inlineThread
+	for _, file := range files {
+		ts = append(ts, fileThreads[file]...)
+	}
+	return ts
+}
+
+// reviewThreads returns threads that are started by inline comments of review r.
+func reviewThreads(threads []*inlineThread, r change.Review) []*inlineThread {
+	ids := make(map[string]bool)
+	for _, c := range r.Comments {
+		ids[c.ID] = true
+	}
+	var ts []*inlineThread
+	for _, t := range threads {
+		if ids[t.ID()] {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// unresolvedThreads returns the number of unresolved threads,
+// or 0 if the change service doesn't support resolving them.
+func unresolvedThreads(threads []*inlineThread) int {
+	var n int
+	for _, t := range threads {
+		if t.Resolvable && !t.Resolved {
+			n++
+		}
+	}
+	return n
+}
+
+// ThreadHandler is the handler for "/{changeID}/threads/{commentID}" endpoint.
+// It performs an action on the thread started by inline comment commentID,
+// submitted via a form, then redirects back to the page the form was on.
+// The action is one of "reply", "resolve", "unresolve".
+func (h *handler) ThreadHandler(w http.ResponseWriter, req *http.Request, changeID uint64, commentID string) error {
+	if req.Method != http.MethodPost {
+		return httperror.Method{Allowed: []string{http.MethodPost}}
+	}
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+	ths, ok := h.cs.(threadService)
+	if !ok {
+		return httperror.HTTP{Code: http.StatusNotFound, Err: errors.New("change service doesn't support threads")}
+	}
+	state, err := h.state(req, changeID)
+	if err != nil {
+		return err
+	}
+	if state.CurrentUser.ID == 0 {
+		return os.ErrPermission
+	}
+
+	// Only redirect back to pages that display threads.
+	returnPath := fmt.Sprintf("/%d", changeID)
+	if r := req.PostFormValue("return"); r == fmt.Sprintf("/%d/files", changeID) {
+		returnPath = r
+	}
+	fragment := "thread-" + commentID
+
+	switch action := req.PostFormValue("action"); action {
+	case "reply":
+		body := req.PostFormValue("body")
+		if strings.TrimSpace(body) == "" {
+			return httperror.BadRequest{Err: errors.New("reply body must not be empty")}
+		}
+		reply, err := ths.ReplyToInlineComment(req.Context(), state.RepoSpec, state.ChangeID, commentID, body)
+		if err != nil {
+			return fmt.Errorf("ReplyToInlineComment: %v", err)
+		}
+		fragment = "comment-" + reply.ID
+	case "resolve", "unresolve":
+		err := ths.SetThreadResolved(req.Context(), state.RepoSpec, state.ChangeID, commentID, action == "resolve")
+		if err != nil {
+			return fmt.Errorf("SetThreadResolved: %v", err)
+		}
+	default:
+		return httperror.BadRequest{Err: fmt.Errorf("unsupported action %q", action)}
+	}
+	return httperror.Redirect{URL: state.BaseURI + returnPath + "#" + fragment}
+}
@@ -0,0 +1,110 @@
+package changes
+
+import (
+	"reflect"
+	"testing"
+
+	"dmitri.shuralyov.com/service/change"
+)
+
+// comment returns an inline comment with id on line of file.
+func comment(id, file string, line int) reviewComment {
+	return reviewComment{InlineComment: change.InlineComment{ID: id, File: file, Line: line}}
+}
+
+// threadIDs returns the IDs of comments in each of threads.
+func threadIDs(threads []*inlineThread) [][]string {
+	var ids [][]string
+	for _, t := range threads {
+		var thread []string
+		for _, c := range t.Comments {
+			thread = append(thread, c.ID)
+		}
+		ids = append(ids, thread)
+	}
+	return ids
+}
+
+func TestGroupThreads(t *testing.T) {
+	comments := []reviewComment{
+		comment("1", "a.go", 1),
+		comment("2", "a.go", 1),
+		comment("3", "b.go", 1),
+		comment("4", "a.go", 1),
+		comment("5", "a.go", 2),
+	}
+	tests := []struct {
+		name     string
+		replyTo  map[string]string
+		resolved map[string]bool
+		want     [][]string
+		wantRes  []bool
+	}{
+		{
+			name:    "by line",
+			want:    [][]string{{"1", "2", "4"}, {"3"}, {"5"}},
+			wantRes: []bool{false, false, false},
+		},
+		{
+			name:     "by replies",
+			replyTo:  map[string]string{"2": "1", "4": "2", "5": "3"},
+			resolved: map[string]bool{"1": true},
+			want:     [][]string{{"1", "2", "4"}, {"3", "5"}},
+			wantRes:  []bool{true, false},
+		},
+		{
+			name:    "reply to missing comment",
+			replyTo: map[string]string{"2": "0"},
+			want:    [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}},
+			wantRes: []bool{false, false, false, false, false},
+		},
+		{
+			name:    "cycle",
+			replyTo: map[string]string{"1": "2", "2": "1"},
+			want:    [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}},
+			wantRes: []bool{false, false, false, false, false},
+		},
+	}
+	for _, tc := range tests {
+		threads := groupThreads(comments, tc.replyTo, tc.resolved, tc.replyTo != nil)
+		if got := threadIDs(threads); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: groupThreads: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		var gotRes []bool
+		for _, th := range threads {
+			gotRes = append(gotRes, th.Resolved)
+			if th.Resolvable != (tc.replyTo != nil) {
+				t.Errorf("%s: thread %s: got Resolvable %v, want %v", tc.name, th.ID(), th.Resolvable, tc.replyTo != nil)
+			}
+		}
+		if !reflect.DeepEqual(gotRes, tc.wantRes) {
+			t.Errorf("%s: groupThreads: got resolved %v, want %v", tc.name, gotRes, tc.wantRes)
+		}
+	}
+}
+
+func TestUnresolvedThreads(t *testing.T) {
+	threads := []*inlineThread{
+		{Resolvable: true},
+		{Resolvable: true, Resolved: true},
+		{Resolvable: true},
+		{Resolvable: false},
+	}
+	if got, want := unresolvedThreads(threads), 2; got != want {
+		t.Errorf("unresolvedThreads: got %d, want %d", got, want)
+	}
+}
+
+func TestSortedByFile(t *testing.T) {
+	threads := []*inlineThread{
+		{Comments: []reviewComment{comment("1", "b.go", 3)}},
+		{Comments: []reviewComment{comment("2", "a.go", 9)}},
+		{Comments: []reviewComment{comment("3", "b.go", 1)}},
+		{Comments: []reviewComment{comment("4", "a.go", 2)}},
+	}
+	got := threadIDs(sortedByFile(threadsByFile(threads)))
+	if want := [][]string{{"2"}, {"4"}, {"1"}, {"3"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortedByFile: got %v, want %v", got, want)
+	}
+}